@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/hub"
+	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
+)
+
+// Config is the provider configuration, passed to the resources as meta
+type Config struct {
+	Credentials               string
+	AccessToken               string
+	Project                   string
+	ImpersonateServiceAccount string
+	KubeConfigFile            string
+	KubeContext               string
+	HubEndpoint               string
+	RetryPolicy               hub.RetryPolicy
+
+	// HubService is the authenticated gkehub API client shared by all the resources
+	HubService *hub.Service
+}
+
+// loadAndValidate initializes the gkehub service with the provider credentials
+func (c *Config) loadAndValidate(ctx context.Context) error {
+	svc, err := hub.NewService(ctx, hub.Credentials{
+		Credentials:               c.Credentials,
+		AccessToken:               c.AccessToken,
+		ImpersonateServiceAccount: c.ImpersonateServiceAccount,
+	}, c.HubEndpoint, c.RetryPolicy)
+	if err != nil {
+		return fmt.Errorf("Initializing gkehub service: %w", err)
+	}
+	svc.UserAgent = "terraform-provider-anthos"
+	c.HubService = svc
+	return nil
+}
+
+// resourceGetter is the read access to resource attributes shared
+// by schema.ResourceData and schema.ResourceDiff
+type resourceGetter interface {
+	GetOk(key string) (interface{}, bool)
+}
+
+// getProject returns the project set in the resource attribute,
+// falling back to the provider default project
+func (c *Config) getProject(d resourceGetter, attribute string) (string, error) {
+	if project, ok := d.GetOk(attribute); ok {
+		return project.(string), nil
+	}
+	if c.Project != "" {
+		return c.Project, nil
+	}
+	return "", fmt.Errorf("%v is not set and there is no provider default project", attribute)
+}
+
+// getK8sAuth returns the Kubernetes auth info of a resource,
+// falling back to the provider defaults for the attributes not set
+func (c *Config) getK8sAuth(d resourceGetter) k8s.Auth {
+	k8sAuth := k8s.Auth{
+		KubeConfigFile: c.KubeConfigFile,
+		KubeContext:    c.KubeContext,
+	}
+	if kubeConfigFile, ok := d.GetOk("k8s_config_file"); ok {
+		k8sAuth.KubeConfigFile = kubeConfigFile.(string)
+	}
+	if kubeContext, ok := d.GetOk("k8s_context"); ok {
+		k8sAuth.KubeContext = kubeContext.(string)
+	}
+	return k8sAuth
+}
@@ -13,7 +13,7 @@ const logFile string = "/tmp/golog.log"
 // GoLog writes a string to a file
 func GoLog(logEntry string) {
     if  DebugMode {
-        f, err := os.OpenFile(logFile, os.O_RDWR | os.O_CREATE | os.O_APPEND, 0666)
+        f, err := os.OpenFile(logFile, os.O_RDWR | os.O_CREATE | os.O_APPEND, 0600)
         if err != nil {
             panic(err)
         }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// attributeError returns an error diagnostic pointing at a resource attribute
+func attributeError(attribute string, summary string, err error) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity:      diag.Error,
+		Summary:       summary,
+		Detail:        err.Error(),
+		AttributePath: cty.GetAttrPath(attribute),
+	}
+}
+
+// warning returns a warning diagnostic
+func warning(summary string, detail string) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  summary,
+		Detail:   detail,
+	}
+}
+
+// k8sAuthAttribute returns the attribute the Kubernetes access of a resource
+// comes from, k8s_context unless only k8s_config_file is set
+func k8sAuthAttribute(d *schema.ResourceData) string {
+	if _, ok := d.GetOk("k8s_context"); ok {
+		return "k8s_context"
+	}
+	if _, ok := d.GetOk("k8s_config_file"); ok {
+		return "k8s_config_file"
+	}
+	return "k8s_context"
+}
+
+// checkK8sAuth makes sure the Kubernetes credentials of a resource can be loaded,
+// so a wrong kubeconfig or context is reported against the attribute at fault
+func checkK8sAuth(d *schema.ResourceData, k8sAuth k8s.Auth) diag.Diagnostics {
+	if _, err := k8s.KubeClientSet(k8sAuth); err != nil {
+		return diag.Diagnostics{attributeError(k8sAuthAttribute(d), "Invalid Kubernetes credentials", err)}
+	}
+	return nil
+}
+
+// checkGCPSAKey makes sure gcp_sa_key holds a service account JSON key.
+// The key contents never make it into the diagnostic
+func checkGCPSAKey(d *schema.ResourceData) diag.Diagnostics {
+	var key struct {
+		Type string `json:"type"`
+	}
+	// json errors may quote the key contents, only tell the key is not JSON
+	if err := json.Unmarshal([]byte(d.Get("gcp_sa_key").(string)), &key); err != nil {
+		return diag.Diagnostics{attributeError("gcp_sa_key", "Invalid GCP service account key", errors.New("The key is not valid JSON"))}
+	}
+	if key.Type != "service_account" {
+		return diag.Diagnostics{attributeError("gcp_sa_key", "Invalid GCP service account key", fmt.Errorf("Expected a service_account key, got type %q", key.Type))}
+	}
+	return nil
+}
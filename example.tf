@@ -1,5 +1,10 @@
 # This is just an example file
 
+provider "anthos" {
+  project     = "mayara-anthos"
+  k8s_context = "mayara-eks"
+}
+
 resource "anthos_cluster_membership" "mayara_eks" {
   cluster_name   = "mayara-eks"
   k8s_context    = "mayara-eks"
@@ -1,17 +1,31 @@
 package hub
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
 	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 	htransport "google.golang.org/api/transport/http"
 )
 
 const prodAddr = "https://gkehub.googleapis.com/"
+
+// EndpointEnvVar can be used to point the gkehub client to another endpoint
+// e.g. a Private Service Connect one, when none is configured explicitly
+const EndpointEnvVar = "GOOGLE_GKE_HUB_CUSTOM_ENDPOINT"
 const userAgent = "gcloud-golang-hub/20200520"
 
 const (
@@ -27,7 +41,10 @@ type Client struct {
 	location  string // location of the membership
 	Resource  Resource
 	K8S       K8S
-	ctx       context.Context
+
+	// RetryPolicy applies to the API requests of the client operations,
+	// it defaults to the service one
+	RetryPolicy RetryPolicy
 }
 
 // K8S contains the membership K8S manifests
@@ -40,48 +57,127 @@ type K8S struct {
 
 // Service type contains the http client and its context info
 type Service struct {
-	client    *http.Client
-	BasePath  string // API endpoint base URL
-	UserAgent string // optional additional User-Agent fragment
+	client      *http.Client
+	BasePath    string             // API endpoint base URL
+	UserAgent   string             // optional additional User-Agent fragment
+	tokenSource oauth2.TokenSource // also used to authenticate against GKE clusters
+	RetryPolicy RetryPolicy        // default retry policy of the API requests
 }
 
-// GetOptionsWithCreds initializes a GKEhub client object
-func GetOptionsWithCreds(project string) (option.ClientOption, error) {
-	// Get default credentials https://godoc.org/golang.org/x/oauth2/google
-	creds, err := google.FindDefaultCredentials(ctx, "")
-	if err != nil {
-		return nil, fmt.Errorf("Getting credentials: %w", err)
+// Credentials contains the GCP authentication settings used to call the gkehub API
+type Credentials struct {
+	// Path to or contents of a JSON credentials file, e.g. a service account key
+	Credentials string
+	// OAuth2 access token, takes precedence over Credentials
+	AccessToken string
+	// Service account to impersonate using the credentials above
+	ImpersonateServiceAccount string
+}
+
+// GetTokenSource builds the OAuth2 token source used to authenticate against Google APIs.
+// If no credentials nor access token are set, Application Default Credentials are used
+func GetTokenSource(ctx context.Context, creds Credentials) (oauth2.TokenSource, error) {
+	var tokenSource oauth2.TokenSource
+	switch {
+	case creds.AccessToken != "":
+		tokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: creds.AccessToken})
+	case creds.Credentials != "":
+		contents, err := readCredentials(creds.Credentials)
+		if err != nil {
+			return nil, fmt.Errorf("Reading credentials: %w", err)
+		}
+		googleCreds, err := google.CredentialsFromJSON(ctx, contents, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing credentials: %w", err)
+		}
+		tokenSource = googleCreds.TokenSource
+	default:
+		// Get default credentials https://godoc.org/golang.org/x/oauth2/google
+		googleCreds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("Getting credentials: %w", err)
+		}
+		tokenSource = googleCreds.TokenSource
+	}
+
+	if creds.ImpersonateServiceAccount != "" {
+		impersonated, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: creds.ImpersonateServiceAccount,
+			Scopes:          []string{cloudPlatformScope},
+		}, option.WithTokenSource(tokenSource))
+		if err != nil {
+			return nil, fmt.Errorf("Impersonating %v: %w", creds.ImpersonateServiceAccount, err)
+		}
+		tokenSource = impersonated
 	}
-	// Create google api options with the generated credentials
-	options := option.WithCredentials(creds)
 
-	return options, nil
+	return tokenSource, nil
 }
 
-// NewClient creates a GKE hub client
-func NewClient(ctx context.Context, projectID string, k8sAuth k8s.Auth) (*Client, error) {
-	options, err := GetOptionsWithCreds(projectID)
+// readCredentials returns the contents of credentials if it is a file path,
+// or credentials itself if it is not
+func readCredentials(credentials string) ([]byte, error) {
+	if _, err := os.Stat(credentials); err == nil {
+		return ioutil.ReadFile(credentials)
+	}
+	return []byte(credentials), nil
+}
+
+// NewService creates the authenticated http client used to call the gkehub API.
+// It is meant to be created once and shared by all the hub clients.
+// If endpoint is empty, EndpointEnvVar or the production endpoint are used.
+// Zero fields of retryPolicy take the DefaultRetryPolicy values
+func NewService(ctx context.Context, creds Credentials, endpoint string, retryPolicy RetryPolicy) (*Service, error) {
+	if err := retryPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("Validating retry policy: %w", err)
+	}
+	tokenSource, err := GetTokenSource(ctx, creds)
 	if err != nil {
-		return nil, fmt.Errorf("Getting options with credentials: %w", err)
+		return nil, fmt.Errorf("Getting token source: %w", err)
+	}
+	if endpoint == "" {
+		endpoint = os.Getenv(EndpointEnvVar)
+	}
+	if endpoint == "" {
+		endpoint = prodAddr
+	}
+	// All the API paths are appended to the base path
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
 	}
 	// These are standard google api options
 	o := []option.ClientOption{
-		option.WithEndpoint(prodAddr),
+		option.WithEndpoint(endpoint),
 		option.WithScopes(cloudPlatformScope),
 		option.WithUserAgent(userAgent),
+		option.WithTokenSource(tokenSource),
 	}
-	o = append(o, options)
 
 	// Create the client that actually makes the api REST requests
-	httpClient, endpoint, err := htransport.NewClient(ctx, o...)
+	httpClient, basePath, err := htransport.NewClient(ctx, o...)
 	if err != nil {
 		return nil, fmt.Errorf("dialing: %v", err)
 	}
 
-	// Populate the svc object of the client
-	s := &Service{
-		client:   httpClient,
-		BasePath: endpoint,
+	return &Service{
+		client:      httpClient,
+		BasePath:    basePath,
+		tokenSource: tokenSource,
+		RetryPolicy: retryPolicy.withDefaults(),
+	}, nil
+}
+
+// DefaultLocation is the location used by memberships when none is set
+const DefaultLocation = "global"
+
+// NewClient creates a GKE hub client on top of an already authenticated service.
+// An empty location means DefaultLocation
+func NewClient(svc *Service, projectID string, location string, k8sAuth k8s.Auth) (*Client, error) {
+	if svc == nil {
+		return nil, fmt.Errorf("The gkehub service is not initialized")
+	}
+	if location == "" {
+		location = DefaultLocation
 	}
 
 	// Populate the K8S object
@@ -90,20 +186,141 @@ func NewClient(ctx context.Context, projectID string, k8sAuth k8s.Auth) (*Client
 	}
 	// Populate the Client object itself
 	c := &Client{
-		projectID: projectID,
-		svc:       s,
-		//FIXME not sure this should be hardcoded, but the api works as global, it will probably change in the future
-		location: "global",
-		K8S:      k,
-		ctx:      ctx,
+		projectID:   projectID,
+		svc:         svc,
+		location:    location,
+		K8S:         k,
+		RetryPolicy: svc.RetryPolicy,
 	}
 
 	return c, nil
 }
 
+// do sends a JSON request to the API and decodes the response into out, if not nil.
+// path is relative to the service base path, e.g. v1/projects/p/locations/global/memberships,
+// unless it is an absolute URL. body, if not nil, is sent JSON encoded.
+// Requests are retried following the client retry policy, and failed
+// responses are returned as an *APIError
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	APIURL := c.svc.BasePath + path
+	u, err := url.Parse(APIURL)
+	if err != nil {
+		return fmt.Errorf("Parsing %v url: %w", APIURL, err)
+	}
+	q := url.Values{}
+	for key, values := range query {
+		q[key] = values
+	}
+	q.Set("alt", "json")
+	u.RawQuery = q.Encode()
+
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("Marshaling %v request body: %w", method, err)
+		}
+		requestBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), requestBody)
+	if err != nil {
+		return fmt.Errorf("Creating %v request: %w", method, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// The transport prepends the base user agent
+	if c.svc.UserAgent != "" {
+		req.Header.Set("User-Agent", c.svc.UserAgent)
+	}
+
+	debug.GoLog("do: " + method + " " + u.Path)
+	response, err := c.send(req)
+	if err != nil {
+		return fmt.Errorf("%v request: %w", method, err)
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("Reading %v response body: %w", method, err)
+	}
+	// Responses may carry secrets, e.g. connect manifests or cluster credentials,
+	// so bodies are only logged on failures, and redacted
+	debug.GoLog("do: " + method + " " + u.Path + " returned " + response.Status)
+
+	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
+	if !statusOK {
+		debug.GoLog("do: " + method + " " + u.Path + " response body: " + redactBody(responseBody))
+		return newAPIError(response.StatusCode, responseBody)
+	}
+
+	if out != nil && len(responseBody) > 0 {
+		err = json.Unmarshal(responseBody, out)
+		if err != nil {
+			return fmt.Errorf("json Un-marshaling %v response body: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// sensitiveFields are the JSON fields whose values never make it to the debug log
+var sensitiveFields = map[string]bool{
+	"imagePullSecretContent": true,
+	"manifest":               true,
+	"masterAuth":             true,
+	"password":               true,
+	"clientKey":              true,
+	"privateKey":             true,
+	"private_key":            true,
+	"accessToken":            true,
+	"access_token":           true,
+	"token":                  true,
+}
+
+// redactBody returns body with the values of sensitiveFields replaced, for logging.
+// Bodies that are not JSON are not logged at all
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return fmt.Sprintf("<%v bytes, not JSON>", len(body))
+	}
+	redacted, err := json.Marshal(redactValue(document))
+	if err != nil {
+		return fmt.Sprintf("<%v bytes>", len(body))
+	}
+	return string(redacted)
+}
+
+// redactValue replaces the values of sensitiveFields found at any depth of a decoded JSON value
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if sensitiveFields[key] {
+				v[key] = "REDACTED"
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// parentRef returns the resource name of the client memberships parent collection
+func (c *Client) parentRef() ParentRef {
+	return GetParentRef(c.projectID, c.location)
+}
+
 // GetKubeUUID grabs the namespace UID of the K8s cluster
-func (c *Client) GetKubeUUID() error {
-	kubeUUID, err := k8s.GetK8sClusterUUID(c.ctx, c.K8S.Auth)
+func (c *Client) GetKubeUUID(ctx context.Context) error {
+	kubeUUID, err := k8s.GetK8sClusterUUID(ctx, c.K8S.Auth)
 	if err != nil {
 		return fmt.Errorf("Getting uuid: %w", err)
 	}
@@ -112,13 +329,13 @@ func (c *Client) GetKubeUUID() error {
 }
 
 // GetKubeArtifacts grabs the K8s CRD and manifest resource if existing
-func (c *Client) GetKubeArtifacts() error {
-	membershipCRD, err := k8s.GetMembershipCRD(c.ctx, c.K8S.Auth)
+func (c *Client) GetKubeArtifacts(ctx context.Context) error {
+	membershipCRD, err := k8s.GetMembershipCRD(ctx, c.K8S.Auth)
 	if err != nil {
 		return fmt.Errorf("Getting membership k8s crd: %w", err)
 	}
 	if membershipCRD != "" {
-		membershipCR, err := k8s.GetMembershipCR(c.ctx, c.K8S.Auth)
+		membershipCR, err := k8s.GetMembershipCR(ctx, c.K8S.Auth)
 		if err != nil {
 			return fmt.Errorf("Getting membership k8s resource: %w", err)
 		}
@@ -128,3 +345,31 @@ func (c *Client) GetKubeArtifacts() error {
 
 	return nil
 }
+
+// SetAuthority populates the membership authority following opts.
+// The cluster is reached with the client K8S auth info if the issuer
+// has to be discovered or the JWKS uploaded
+func (c *Client) SetAuthority(ctx context.Context, opts AuthorityOptions) error {
+	var err error
+	issuer := opts.IssuerURL
+	if issuer == "" && opts.EnableWorkloadIdentity {
+		issuer, err = k8s.GetOIDCIssuer(ctx, c.K8S.Auth)
+		if err != nil {
+			return fmt.Errorf("Discovering the cluster OIDC issuer: %w", err)
+		}
+	}
+	c.Resource.Authority.Issuer = issuer
+	c.Resource.Authority.OIDCJWKS = nil
+
+	if opts.UploadOIDCJWKS {
+		if issuer == "" {
+			return fmt.Errorf("An issuer is needed to upload the cluster OIDC JWKS")
+		}
+		c.Resource.Authority.OIDCJWKS, err = k8s.GetOIDCJWKS(ctx, c.K8S.Auth)
+		if err != nil {
+			return fmt.Errorf("Getting the cluster OIDC JWKS: %w", err)
+		}
+	}
+
+	return nil
+}
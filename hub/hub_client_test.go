@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
+)
+
+// newTestClient returns a client whose service points to handler
+// through the endpoint override, as set by the hub_endpoint provider attribute
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	svc, err := NewService(context.Background(), Credentials{AccessToken: "test-token"}, server.URL, RetryPolicy{Attempts: 1})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	svc.UserAgent = "terraform-provider-anthos"
+	client, err := NewClient(svc, "my-project", "", k8s.Auth{})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+func TestDo(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization header = %q", got)
+		}
+		if got := r.Header.Get("User-Agent"); !strings.Contains(got, "terraform-provider-anthos") {
+			t.Errorf("User-Agent header = %q", got)
+		}
+		if got := r.URL.Query().Get("alt"); got != "json" {
+			t.Errorf("alt query parameter = %q", got)
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/projects/my-project/locations/global/memberships/my-cluster":
+			w.Write([]byte(`{"name":"projects/my-project/locations/global/memberships/my-cluster","externalId":"uuid","state":{"code":"READY"}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/my-project/locations/global/memberships":
+			if got := r.URL.Query().Get("membershipId"); got != "my-cluster" {
+				t.Errorf("membershipId query parameter = %q", got)
+			}
+			if got := r.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type header = %q", got)
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			var membership map[string]interface{}
+			if err := json.Unmarshal(body, &membership); err != nil || membership["description"] != "my cluster" {
+				t.Errorf("POST body = %s", body)
+			}
+			w.Write([]byte(`{"name":"operations/create","done":false}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Resource not found","status":"NOT_FOUND"}}`))
+		}
+	})
+	ctx := context.Background()
+
+	var resource Resource
+	err := client.do(ctx, http.MethodGet, "v1/projects/my-project/locations/global/memberships/my-cluster", nil, nil, &resource)
+	if err != nil {
+		t.Fatalf("GET: unexpected error: %v", err)
+	}
+	if resource.ExternalID != "uuid" || resource.State.Code != MembershipStateReady {
+		t.Errorf("GET decoded %+v", resource)
+	}
+
+	var operation Operation
+	q := url.Values{}
+	q.Set("membershipId", "my-cluster")
+	err = client.do(ctx, http.MethodPost, "v1/projects/my-project/locations/global/memberships", q, map[string]string{"description": "my cluster"}, &operation)
+	if err != nil {
+		t.Fatalf("POST: unexpected error: %v", err)
+	}
+	if operation.Name != "operations/create" {
+		t.Errorf("POST decoded %+v", operation)
+	}
+
+	err = client.do(ctx, http.MethodGet, "v1/projects/my-project/locations/global/memberships/missing", nil, nil, &resource)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusNotFound || apiErr.Message != "Resource not found" || !IsNotFound(err) {
+		t.Errorf("GET missing membership returned %#v", err)
+	}
+}
+
+func TestGetMembershipNotFound(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	err := client.GetMembership(context.Background(), "my-cluster", false)
+	if !errors.Is(err, ErrMembershipNotFound) {
+		t.Errorf("GetMembership() = %v, expected it to wrap ErrMembershipNotFound", err)
+	}
+	if err := client.GetMembership(context.Background(), "my-cluster", true); err != nil {
+		t.Errorf("GetMembership() checking the membership does not exist = %v", err)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	cases := []struct {
+		body string
+		want string
+	}{
+		{body: "", want: ""},
+		{body: `{"error":{"code":400,"message":"bad request"}}`, want: `{"error":{"code":400,"message":"bad request"}}`},
+		{body: `{"manifest":[{"manifest":"kind: Secret","type":"install"}],"imagePullSecretContent":"c2VjcmV0"}`, want: `{"imagePullSecretContent":"REDACTED","manifest":"REDACTED"}`},
+		{body: `{"endpoint":"1.2.3.4","masterAuth":{"clusterCaCertificate":"Y2E="}}`, want: `{"endpoint":"1.2.3.4","masterAuth":"REDACTED"}`},
+		{body: `[{"token":"abc"},{"name":"x"}]`, want: `[{"token":"REDACTED"},{"name":"x"}]`},
+		{body: "<html>secret</html>", want: "<19 bytes, not JSON>"},
+	}
+	for _, c := range cases {
+		if got := redactBody([]byte(c.body)); got != c.want {
+			t.Errorf("redactBody(%q) = %q, expected %q", c.body, got, c.want)
+		}
+	}
+}
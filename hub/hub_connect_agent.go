@@ -1,9 +1,8 @@
 package hub
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"net/http"
 	"net/url"
 
 	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
@@ -19,20 +18,14 @@ type ConnectAgent struct {
 	ImagePullSecretContent string
 	Response               k8s.ConnectManifestResponse
 	GCPSAKey               string
+	// Replace the credentials secret on upgrades, whose manifests do not carry it
+	UpdateGCPSAKey bool
 }
 
 // GenerateConnectManifest asks the gkehub API for a gke-connect-agent manifest
-func (c *Client) GenerateConnectManifest(proxy string, namespace string, version string, isUpgrade bool, registry string, imagePullSecretContent string) (k8s.ConnectManifestResponse, error) {
+func (c *Client) GenerateConnectManifest(ctx context.Context, proxy string, namespace string, version string, isUpgrade bool, registry string, imagePullSecretContent string) (k8s.ConnectManifestResponse, error) {
 	var result k8s.ConnectManifestResponse
-	// Create a url object to append parameters to it
-	APIURL := prodAddr + "v1beta1/" + c.Resource.Name + ":generateConnectManifest"
-	// Create the url parameters
-	u, err := url.Parse(APIURL)
-	if err != nil {
-		return result, fmt.Errorf("Parsing %v url: %w", APIURL, err)
-	}
-	q := u.Query()
-	q.Set("alt", "json")
+	q := url.Values{}
 	q.Set("name", c.Resource.Name)
 	if proxy != "" {
 		q.Set("connectAgent.proxy", proxy)
@@ -52,28 +45,6 @@ func (c *Client) GenerateConnectManifest(proxy string, namespace string, version
 	if imagePullSecretContent != "" {
 		q.Set("imagePullSecretContent", imagePullSecretContent)
 	}
-	u.RawQuery = q.Encode()
-	// Go ahead with the request
-	response, err := c.svc.client.Get(u.String())
-	if err != nil {
-		return result, fmt.Errorf("GET request: %w", err)
-	}
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return result, fmt.Errorf("reading get request body: %w", err)
-	}
-
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return result, fmt.Errorf("Bad status code: %v", string(body))
-	}
-
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return result, fmt.Errorf("json Un-marshaling body: %w", err)
-	}
-
-	return result, nil
-
+	err := c.do(ctx, http.MethodGet, "v1beta1/"+c.Resource.Name+":generateConnectManifest", q, nil, &result)
+	return result, err
 }
@@ -0,0 +1,156 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Canonical google.rpc error codes
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+const (
+	CodeOK                 int32 = 0
+	CodeCancelled          int32 = 1
+	CodeUnknown            int32 = 2
+	CodeInvalidArgument    int32 = 3
+	CodeDeadlineExceeded   int32 = 4
+	CodeNotFound           int32 = 5
+	CodeAlreadyExists      int32 = 6
+	CodePermissionDenied   int32 = 7
+	CodeResourceExhausted  int32 = 8
+	CodeFailedPrecondition int32 = 9
+	CodeAborted            int32 = 10
+	CodeOutOfRange         int32 = 11
+	CodeUnimplemented      int32 = 12
+	CodeInternal           int32 = 13
+	CodeUnavailable        int32 = 14
+	CodeDataLoss           int32 = 15
+	CodeUnauthenticated    int32 = 16
+)
+
+// rpcCodes maps the google.rpc code names, as found in the error status field, to their values
+var rpcCodes = map[string]int32{
+	"OK":                  CodeOK,
+	"CANCELLED":           CodeCancelled,
+	"UNKNOWN":             CodeUnknown,
+	"INVALID_ARGUMENT":    CodeInvalidArgument,
+	"DEADLINE_EXCEEDED":   CodeDeadlineExceeded,
+	"NOT_FOUND":           CodeNotFound,
+	"ALREADY_EXISTS":      CodeAlreadyExists,
+	"PERMISSION_DENIED":   CodePermissionDenied,
+	"RESOURCE_EXHAUSTED":  CodeResourceExhausted,
+	"FAILED_PRECONDITION": CodeFailedPrecondition,
+	"ABORTED":             CodeAborted,
+	"OUT_OF_RANGE":        CodeOutOfRange,
+	"UNIMPLEMENTED":       CodeUnimplemented,
+	"INTERNAL":            CodeInternal,
+	"UNAVAILABLE":         CodeUnavailable,
+	"DATA_LOSS":           CodeDataLoss,
+	"UNAUTHENTICATED":     CodeUnauthenticated,
+}
+
+// httpCodes maps HTTP status codes to the google.rpc code they usually
+// carry, used when the error body has no status
+var httpCodes = map[int]int32{
+	http.StatusBadRequest:          CodeInvalidArgument,
+	http.StatusUnauthorized:        CodeUnauthenticated,
+	http.StatusForbidden:           CodePermissionDenied,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeAlreadyExists,
+	http.StatusTooManyRequests:     CodeResourceExhausted,
+	http.StatusNotImplemented:      CodeUnimplemented,
+	http.StatusServiceUnavailable:  CodeUnavailable,
+	http.StatusGatewayTimeout:      CodeDeadlineExceeded,
+	http.StatusInternalServerError: CodeInternal,
+}
+
+// APIError is an error returned by a Google API, see
+// https://cloud.google.com/apis/design/errors#http_mapping
+type APIError struct {
+	// HTTP status code of the response, 0 for errors not coming from an HTTP response,
+	// e.g. the status of a failed operation
+	HTTPStatus int
+	// google.rpc code, one of the Code constants
+	Code int32
+	// google.rpc code name, e.g. ALREADY_EXISTS
+	Status string
+	// Developer-facing error message
+	Message string
+	// Additional error information, e.g. google.rpc.ErrorInfo
+	Details []map[string]interface{}
+}
+
+func (e *APIError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = fmt.Sprintf("code %v", e.Code)
+	}
+	if e.HTTPStatus != 0 {
+		return fmt.Sprintf("gkehub API error %v (%v): %v", e.HTTPStatus, status, e.Message)
+	}
+	return fmt.Sprintf("gkehub API error (%v): %v", status, e.Message)
+}
+
+// newAPIError decodes the body of a failed API response. Bodies not
+// following the Google error format are kept as the error message
+func newAPIError(httpStatus int, body []byte) *APIError {
+	var response struct {
+		Error struct {
+			Message string                   `json:"message"`
+			Status  string                   `json:"status"`
+			Details []map[string]interface{} `json:"details"`
+		} `json:"error"`
+	}
+	apiErr := &APIError{HTTPStatus: httpStatus, Message: string(body)}
+	if err := json.Unmarshal(body, &response); err == nil && response.Error.Message != "" {
+		apiErr.Message = response.Error.Message
+		apiErr.Status = response.Error.Status
+		apiErr.Details = response.Error.Details
+	}
+
+	if code, ok := rpcCodes[apiErr.Status]; ok {
+		apiErr.Code = code
+	} else if code, ok := httpCodes[httpStatus]; ok {
+		apiErr.Code = code
+	} else {
+		apiErr.Code = CodeUnknown
+	}
+	return apiErr
+}
+
+// statusAPIError builds an APIError out of a google.rpc status, e.g. a failed operation one
+func statusAPIError(status GRCPResponseStatus) *APIError {
+	apiErr := &APIError{
+		Code:    status.Code,
+		Message: status.Message,
+		Details: status.Details,
+	}
+	for name, code := range rpcCodes {
+		if code == status.Code {
+			apiErr.Status = name
+		}
+	}
+	return apiErr
+}
+
+// hasCode tells if err is or wraps an APIError with code
+func hasCode(err error, code int32) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// IsNotFound tells if err comes from a missing API resource
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrMembershipNotFound) || hasCode(err, CodeNotFound)
+}
+
+// IsAlreadyExists tells if err comes from creating an API resource that already exists
+func IsAlreadyExists(err error) bool {
+	return errors.Is(err, ErrMembershipAlreadyExists) || hasCode(err, CodeAlreadyExists)
+}
+
+// IsPermissionDenied tells if err comes from the caller lacking permissions on an API resource
+func IsPermissionDenied(err error) bool {
+	return hasCode(err, CodePermissionDenied)
+}
@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	cases := []struct {
+		name       string
+		httpStatus int
+		body       string
+		code       int32
+		status     string
+		message    string
+		details    int
+	}{
+		{
+			name:       "google error",
+			httpStatus: http.StatusConflict,
+			body:       `{"error":{"code":409,"message":"Membership already exists","status":"ALREADY_EXISTS","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"ALREADY_EXISTS"}]}}`,
+			code:       CodeAlreadyExists,
+			status:     "ALREADY_EXISTS",
+			message:    "Membership already exists",
+			details:    1,
+		},
+		{
+			name:       "status wins over the HTTP status",
+			httpStatus: http.StatusBadRequest,
+			body:       `{"error":{"code":400,"message":"Precondition check failed","status":"FAILED_PRECONDITION"}}`,
+			code:       CodeFailedPrecondition,
+			status:     "FAILED_PRECONDITION",
+			message:    "Precondition check failed",
+		},
+		{
+			name:       "no status",
+			httpStatus: http.StatusForbidden,
+			body:       `{"error":{"code":403,"message":"Permission denied"}}`,
+			code:       CodePermissionDenied,
+			message:    "Permission denied",
+		},
+		{
+			name:       "body not following the google format",
+			httpStatus: http.StatusNotFound,
+			body:       "<html>Not Found</html>",
+			code:       CodeNotFound,
+			message:    "<html>Not Found</html>",
+		},
+		{
+			name:       "unmapped HTTP status",
+			httpStatus: http.StatusTeapot,
+			body:       "",
+			code:       CodeUnknown,
+		},
+	}
+	for _, c := range cases {
+		err := newAPIError(c.httpStatus, []byte(c.body))
+		if err.HTTPStatus != c.httpStatus || err.Code != c.code || err.Status != c.status || err.Message != c.message || len(err.Details) != c.details {
+			t.Errorf("%v: newAPIError(%v, %q) = %+v", c.name, c.httpStatus, c.body, err)
+		}
+	}
+}
+
+func TestStatusAPIError(t *testing.T) {
+	err := statusAPIError(GRCPResponseStatus{Code: CodeAlreadyExists, Message: "owned by another hub"})
+	if err.HTTPStatus != 0 || err.Code != CodeAlreadyExists || err.Status != "ALREADY_EXISTS" || err.Message != "owned by another hub" {
+		t.Errorf("statusAPIError() = %+v", err)
+	}
+	if got := err.Error(); got != "gkehub API error (ALREADY_EXISTS): owned by another hub" {
+		t.Errorf("statusAPIError().Error() = %q", got)
+	}
+
+	err = statusAPIError(GRCPResponseStatus{Code: 42, Message: "unknown"})
+	if err.Status != "" {
+		t.Errorf("statusAPIError() with an unknown code set status %q", err.Status)
+	}
+	if got := err.Error(); got != "gkehub API error (code 42): unknown" {
+		t.Errorf("statusAPIError().Error() = %q", got)
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	notFound := fmt.Errorf("Checking membership info: %w", newAPIError(http.StatusNotFound, nil))
+	alreadyExists := fmt.Errorf("Creating: %w", statusAPIError(GRCPResponseStatus{Code: CodeAlreadyExists}))
+	permissionDenied := fmt.Errorf("Listing: %w", newAPIError(http.StatusForbidden, nil))
+	membershipNotFound := fmt.Errorf("my-cluster: %w", ErrMembershipNotFound)
+	membershipExists := fmt.Errorf("my-cluster: %w", ErrMembershipAlreadyExists)
+	operationFailed := fmt.Errorf("Waiting: %w", &OperationError{Operation: "operations/1", Status: GRCPResponseStatus{Code: CodeNotFound}})
+	other := errors.New("connection refused")
+
+	cases := []struct {
+		name      string
+		predicate func(error) bool
+		matching  []error
+		others    []error
+	}{
+		{"IsNotFound", IsNotFound, []error{notFound, membershipNotFound, operationFailed}, []error{alreadyExists, permissionDenied, membershipExists, other, nil}},
+		{"IsAlreadyExists", IsAlreadyExists, []error{alreadyExists, membershipExists}, []error{notFound, permissionDenied, membershipNotFound, other, nil}},
+		{"IsPermissionDenied", IsPermissionDenied, []error{permissionDenied}, []error{notFound, alreadyExists, membershipNotFound, other, nil}},
+	}
+	for _, c := range cases {
+		for _, err := range c.matching {
+			if !c.predicate(err) {
+				t.Errorf("%v(%v) = false, expected true", c.name, err)
+			}
+		}
+		for _, err := range c.others {
+			if c.predicate(err) {
+				t.Errorf("%v(%v) = true, expected false", c.name, err)
+			}
+		}
+	}
+}
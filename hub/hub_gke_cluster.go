@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
+	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
+)
+
+const gkeAddr = "https://container.googleapis.com/v1/"
+
+// ErrGKEClusterNotFound is returned when the GKE cluster of a resource link does not exist
+var ErrGKEClusterNotFound = errors.New("GKE cluster not found")
+
+// gkeClusterResponse contains the GKE cluster fields we need to reach its API server
+type gkeClusterResponse struct {
+	Endpoint   string `json:"endpoint"`
+	MasterAuth struct {
+		ClusterCACertificate string `json:"clusterCaCertificate"`
+	} `json:"masterAuth"`
+}
+
+// GKEClusterPath converts a GKE cluster resource link, e.g.
+// //container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/my-cluster
+// into the projects/{project}/locations/{location}/clusters/{cluster} form.
+// Legacy v1 self links using zones are accepted too
+func GKEClusterPath(resourceLink string) (string, error) {
+	path := resourceLink
+	for _, prefix := range []string{"https:", "//container.googleapis.com/", "v1/"} {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	path = strings.Replace(path, "/zones/", "/locations/", 1)
+	parts := strings.Split(path, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "clusters" ||
+		parts[1] == "" || parts[3] == "" || parts[5] == "" {
+		return "", fmt.Errorf("Unexpected GKE cluster resource link %v", resourceLink)
+	}
+	return path, nil
+}
+
+// GetGKEClusterAuth builds the Kubernetes auth info of a GKE cluster from its resource link.
+// The cluster API server is reached directly, with the same credentials used for the gkehub API.
+// The GKE API is not the gkehub one, so its endpoint, retries and errors are handled here
+func (c *Client) GetGKEClusterAuth(ctx context.Context, resourceLink string) (k8s.Auth, error) {
+	var auth k8s.Auth
+	path, err := GKEClusterPath(resourceLink)
+	if err != nil {
+		return auth, err
+	}
+
+	// Call the GKE api
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gkeAddr+path, nil)
+	if err != nil {
+		return auth, fmt.Errorf("Creating GKE cluster request: %w", err)
+	}
+	if c.svc.UserAgent != "" {
+		req.Header.Set("User-Agent", c.svc.UserAgent)
+	}
+	debug.GoLog("GetGKEClusterAuth: GET " + req.URL.Path)
+	response, err := c.svc.client.Do(req)
+	if err != nil {
+		return auth, fmt.Errorf("Getting GKE cluster %v: %w", path, err)
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return auth, fmt.Errorf("Reading GKE cluster %v: %w", path, err)
+	}
+	// The cluster CA and endpoint are not secret, but the body is not logged anyway
+	debug.GoLog("GetGKEClusterAuth: GET " + req.URL.Path + " returned " + response.Status)
+	if response.StatusCode == http.StatusNotFound {
+		return auth, fmt.Errorf("%v: %w", path, ErrGKEClusterNotFound)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return auth, fmt.Errorf("Getting GKE cluster %v: GKE API returned %v", path, response.Status)
+	}
+	var cluster gkeClusterResponse
+	err = json.Unmarshal(responseBody, &cluster)
+	if err != nil {
+		return auth, fmt.Errorf("json Un-marshaling GKE cluster %v: %w", path, err)
+	}
+
+	caData, err := base64.StdEncoding.DecodeString(cluster.MasterAuth.ClusterCACertificate)
+	if err != nil {
+		return auth, fmt.Errorf("Decoding cluster CA certificate: %w", err)
+	}
+
+	auth.Host = "https://" + cluster.Endpoint
+	auth.CAData = caData
+	auth.TokenSource = c.svc.tokenSource
+
+	return auth, nil
+}
@@ -0,0 +1,40 @@
+package hub
+
+import "testing"
+
+func TestGKEClusterPath(t *testing.T) {
+	const path = "projects/my-project/locations/us-west1-a/clusters/my-cluster"
+	cases := []struct {
+		resourceLink string
+		want         string
+		wantErr      bool
+	}{
+		{resourceLink: "//container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/my-cluster", want: path},
+		{resourceLink: "//container.googleapis.com/projects/my-project/zones/us-west1-a/clusters/my-cluster", want: path},
+		{resourceLink: "//container.googleapis.com/v1/projects/my-project/zones/us-west1-a/clusters/my-cluster", want: path},
+		{resourceLink: "https://container.googleapis.com/v1/projects/my-project/zones/us-west1-a/clusters/my-cluster", want: path},
+		{resourceLink: "projects/my-project/locations/us-west1-a/clusters/my-cluster", want: path},
+		{resourceLink: "", wantErr: true},
+		{resourceLink: "//container.googleapis.com/projects/my-project/locations/us-west1-a", wantErr: true},
+		{resourceLink: "//container.googleapis.com/projects/my-project/locations/us-west1-a/nodePools/my-pool", wantErr: true},
+		{resourceLink: "//container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/my-cluster/nodePools/my-pool", wantErr: true},
+		{resourceLink: "//container.googleapis.com/projects//locations/us-west1-a/clusters/my-cluster", wantErr: true},
+		{resourceLink: "//container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := GKEClusterPath(c.resourceLink)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("GKEClusterPath(%q): expected an error, got %v", c.resourceLink, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("GKEClusterPath(%q): unexpected error: %v", c.resourceLink, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("GKEClusterPath(%q) = %v, expected %v", c.resourceLink, got, c.want)
+		}
+	}
+}
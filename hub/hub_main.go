@@ -2,10 +2,11 @@ package hub
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
 	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
-	"github.com/avast/retry-go"
 )
 
 // ParentRef is the resource name of the parent collection of a membership.
@@ -16,103 +17,258 @@ func GetParentRef(project string, location string) ParentRef {
 	return ParentRef(fmt.Sprintf("projects/%v/locations/%v", project, location))
 }
 
-// Global variable used for various context purposes
-var ctx = context.Background()
+// AuthorityOptions describes how the authority of a membership is set up
+type AuthorityOptions struct {
+	// JWT issuer URI, if empty and EnableWorkloadIdentity is set it is discovered from the cluster
+	IssuerURL              string
+	EnableWorkloadIdentity bool
+	// Send the cluster JWKS along with the issuer, for issuers Google can not reach
+	UploadOIDCJWKS bool
+}
 
 // GetMembership gets a Membership resource from the GKEHub API
-func GetMembership(project string, membershipID string, description string, gkeClusterSelfLink string, issuerURL string, k8sAuth k8s.Auth) error {
-	client, err := NewClient(ctx, project, k8sAuth)
+// If the membership does not exist the returned error wraps ErrMembershipNotFound
+func GetMembership(ctx context.Context, svc *Service, project string, location string, membershipID string, k8sAuth k8s.Auth) (Resource, error) {
+	client, err := NewClient(svc, project, location, k8sAuth)
 	if err != nil {
-		return fmt.Errorf("Getting new client: %w", err)
+		return Resource{}, fmt.Errorf("Getting new client: %w", err)
+	}
+	err = client.GetMembership(ctx, membershipID, false)
+	if err != nil {
+		return Resource{}, err
 	}
-	return client.GetMembership(membershipID, false)
+	return client.Resource, nil
 }
 
 // CreateMembership creates a membership GKEHub resource
-func CreateMembership(project string, membershipID string, description string, gkeClusterSelfLink string, issuerURL string, k8sAuth k8s.Auth) (membershipUUID string, err error) {
-	client, err := NewClient(ctx, project, k8sAuth)
+// An empty description defaults to membershipID, and an empty externalID to the cluster UUID.
+// If gkeClusterSelfLink is set, a GKE membership is created and k8sAuth is
+// ignored, the cluster is reached with the gkehub credentials instead.
+// If the membership got registered but a later step failed, e.g. installing the
+// exclusivity artifacts, membershipUUID is returned along with the error.
+// A membership left behind by such a failure, i.e. one registered with the same
+// external ID (or the cluster UUID if externalID is empty) and endpoint, is adopted:
+// its description, labels and authority are updated and its creation resumed
+func CreateMembership(ctx context.Context, svc *Service, project string, location string, membershipID string, description string, externalID string, labels map[string]string, gkeClusterSelfLink string, authority AuthorityOptions, k8sAuth k8s.Auth) (membershipUUID string, err error) {
+	client, err := NewClient(svc, project, location, k8sAuth)
 	if err != nil {
 		return "", fmt.Errorf("Getting new client: %w", err)
 	}
 
+	// Make sure the API supports the membership location, global always is
+	if location != DefaultLocation {
+		err = client.ValidateLocation(ctx)
+		if err != nil {
+			return "", fmt.Errorf("Validating location: %w", err)
+		}
+	}
+
+	if gkeClusterSelfLink != "" {
+		k8sAuth, err = client.GetGKEClusterAuth(ctx, gkeClusterSelfLink)
+		if err != nil {
+			return "", fmt.Errorf("Getting GKE cluster credentials: %w", err)
+		}
+		client.K8S.Auth = k8sAuth
+	}
+
 	// Get the K8s default namespace UID
-	err = client.GetKubeUUID()
+	err = client.GetKubeUUID(ctx)
 	if err != nil {
 		return "", fmt.Errorf("Getting Kube UID: %w", err)
 	}
 
-	err = client.GetKubeArtifacts()
+	err = client.GetKubeArtifacts(ctx)
 	if err != nil {
 		return "", fmt.Errorf("Getting Kube custom artifacts: %w", err)
 	}
 
-	// Check if membership does not already exist
-	err = client.GetMembership(membershipID, true)
+	err = client.SetAuthority(ctx, authority)
 	if err != nil {
-		return "", fmt.Errorf("Checking if membership does not exist: %w", err)
+		return "", fmt.Errorf("Setting authority: %w", err)
 	}
+	// Getting an existing membership overwrites the resource
+	configuredAuthority := client.Resource.Authority
 
-	// Populate the membership resource fields with the parameters
-	client.Resource.Description = membershipID
-	client.Resource.Endpoint.GKECluster.ResourceLink = gkeClusterSelfLink
-	// Create the membership
-	err = client.CreateMembership(membershipID)
+	// The API requires a description
+	if description == "" {
+		description = membershipID
+	}
+
+	// Check if membership does not already exist
+	err = client.GetMembership(ctx, membershipID, true)
 	if err != nil {
-		return "", fmt.Errorf("Creating membership membership: %w", err)
+		if !IsAlreadyExists(err) || !client.adoptable(externalID) {
+			return "", fmt.Errorf("Checking if membership does not exist: %w", err)
+		}
+		if !client.sameEndpoint(gkeClusterSelfLink) {
+			return "", fmt.Errorf("Adopting membership %v: it is registered for GKE cluster %q, not %q", membershipID, client.Resource.Endpoint.GKECluster.ResourceLink, gkeClusterSelfLink)
+		}
+		debug.GoLog("CreateMembership: adopting membership " + membershipID + " registered with external ID " + client.Resource.ExternalID)
+		// Bring the adopted membership in line with the configuration
+		client.Resource.Description = description
+		client.Resource.Labels = labels
+		client.Resource.Authority.Issuer = configuredAuthority.Issuer
+		client.Resource.Authority.OIDCJWKS = configuredAuthority.OIDCJWKS
+		err = client.UpdateMembership(ctx, []string{"description", "labels", "authority"})
+		if err != nil {
+			return client.K8S.UUID, fmt.Errorf("Updating adopted membership: %w", err)
+		}
+	} else {
+		// Populate the membership resource fields with the parameters
+		client.Resource.Description = description
+		client.Resource.ExternalID = externalID
+		client.Resource.Labels = labels
+		client.Resource.Endpoint.GKECluster.ResourceLink = gkeClusterSelfLink
+		// Create the membership
+		err = client.CreateMembership(ctx, membershipID)
+		if err != nil {
+			// The creation operation started, the membership may exist already
+			if client.Resource.Name != "" {
+				return client.K8S.UUID, fmt.Errorf("Creating membership membership: %w", err)
+			}
+			return "", fmt.Errorf("Creating membership membership: %w", err)
+		}
 	}
 
 	// Get membership info after creation, just to double check that all went fine
-	err = client.GetMembership(membershipID, false)
+	err = client.GetMembership(ctx, membershipID, false)
 	if err != nil {
-		return "", fmt.Errorf("Checking getting membership info after creation: %w", err)
+		return client.K8S.UUID, fmt.Errorf("Checking getting membership info after creation: %w", err)
 	}
 
 	// Get Kubernetes artifacts to install or update the K8s CRD and CR
-	err = client.GenerateExclusivity(membershipID)
+	err = client.GenerateExclusivity(ctx, membershipID)
 	if err != nil {
-		return "", fmt.Errorf("Generating K8s exclusivity artifacts: %w", err)
+		return client.K8S.UUID, fmt.Errorf("Generating K8s exclusivity artifacts: %w", err)
 	}
 
 	// Install the membership CRD and the membership CR in the kubernetes cluster
-	err = k8s.InstallExclusivityManifests(client.ctx, k8sAuth, client.K8S.CRDManifest, client.K8S.CRManifest)
+	err = k8s.InstallExclusivityManifests(ctx, k8sAuth, client.K8S.CRDManifest, client.K8S.CRManifest)
 	if err != nil {
-		return "", fmt.Errorf("Installing CRD and CR manifest in the Kubernetes cluster: %w", err)
+		return client.K8S.UUID, fmt.Errorf("Installing CRD and CR manifest in the Kubernetes cluster: %w", err)
 	}
 
 	return client.K8S.UUID, nil
 }
 
-// DeleteMembership deletes a membership GKEHub resource
-func DeleteMembership(project string, membershipID string, description string, gkeClusterSelfLink string, issuerURL string, k8sAuth k8s.Auth, deleteArtifacts bool) error {
-	client, err := NewClient(ctx, project, k8sAuth)
+// UpdateMembership updates in place the fields of a membership GKEHub resource listed in updateMask
+func UpdateMembership(ctx context.Context, svc *Service, project string, location string, membershipID string, description string, externalID string, labels map[string]string, authority AuthorityOptions, updateMask []string, k8sAuth k8s.Auth) error {
+	client, err := NewClient(svc, project, location, k8sAuth)
 	if err != nil {
 		return fmt.Errorf("Getting new client: %w", err)
 	}
-	// Get membership info
-	err = client.GetMembership(membershipID, false)
+
+	// Get membership info, this populates the resource name
+	err = client.GetMembership(ctx, membershipID, false)
 	if err != nil {
 		return fmt.Errorf("Checking membership info: %w", err)
 	}
 
-	// Delete the membership
-	err = client.DeleteMembership()
+	client.Resource.Description = description
+	client.Resource.ExternalID = externalID
+	client.Resource.Labels = labels
+	for _, field := range updateMask {
+		if field != "authority" {
+			continue
+		}
+		// GKE memberships are not reached through a kubeconfig
+		if client.Resource.Endpoint.GKECluster.ResourceLink != "" {
+			client.K8S.Auth, err = client.GetGKEClusterAuth(ctx, client.Resource.Endpoint.GKECluster.ResourceLink)
+			if err != nil {
+				return fmt.Errorf("Getting GKE cluster credentials: %w", err)
+			}
+		}
+		err = client.SetAuthority(ctx, authority)
+		if err != nil {
+			return fmt.Errorf("Setting authority: %w", err)
+		}
+	}
+	err = client.UpdateMembership(ctx, updateMask)
 	if err != nil {
-		return fmt.Errorf("Deleting membership: %w", err)
+		return fmt.Errorf("Updating membership: %w", err)
 	}
 
-	// Wait until the resource gets deleted
-	retry.Attempts(60)
-	err = retry.Do(
-		func() error {
-			err := client.GetMembership(membershipID, true)
-			if err != nil && client.Resource.State.Code == "DELETING" {
-				return nil
-			}
-			return retry.Unrecoverable(err)
-		})
+	return nil
+}
 
+// GetClusterOIDCJWKS returns the current OIDC JWKS of a cluster, used to detect signing key rotations
+// If gkeClusterSelfLink is set k8sAuth is ignored and the GKE cluster is reached directly
+func GetClusterOIDCJWKS(ctx context.Context, svc *Service, project string, location string, gkeClusterSelfLink string, k8sAuth k8s.Auth) ([]byte, error) {
+	client, err := NewClient(svc, project, location, k8sAuth)
 	if err != nil {
-		return fmt.Errorf("Waiting for resource to me deleted: %w", err)
+		return nil, fmt.Errorf("Getting new client: %w", err)
+	}
+	if gkeClusterSelfLink != "" {
+		k8sAuth, err = client.GetGKEClusterAuth(ctx, gkeClusterSelfLink)
+		if err != nil {
+			return nil, fmt.Errorf("Getting GKE cluster credentials: %w", err)
+		}
+	}
+	return k8s.GetOIDCJWKS(ctx, k8sAuth)
+}
+
+// GetClusterMetadata collects the Kubernetes metadata of a membership cluster straight from
+// the cluster, for memberships the Hub has no metadata about (e.g. without a connect agent).
+// If gkeClusterSelfLink is set k8sAuth is ignored and the GKE cluster is reached directly
+func GetClusterMetadata(ctx context.Context, svc *Service, project string, location string, gkeClusterSelfLink string, k8sAuth k8s.Auth) (KubernetesMetadata, error) {
+	var metadata KubernetesMetadata
+	client, err := NewClient(svc, project, location, k8sAuth)
+	if err != nil {
+		return metadata, fmt.Errorf("Getting new client: %w", err)
+	}
+	if gkeClusterSelfLink != "" {
+		k8sAuth, err = client.GetGKEClusterAuth(ctx, gkeClusterSelfLink)
+		if err != nil {
+			return metadata, fmt.Errorf("Getting GKE cluster credentials: %w", err)
+		}
+	}
+	clusterMetadata, err := k8s.GetClusterMetadata(ctx, k8sAuth)
+	if err != nil {
+		return metadata, fmt.Errorf("Calling GetClusterMetadata: %w", err)
+	}
+
+	metadata.KubernetesAPIServerVersion = clusterMetadata.ServerVersion
+	metadata.NodeProviderID = clusterMetadata.NodeProviderID
+	metadata.NodeCount = clusterMetadata.NodeCount
+	metadata.VCPUCount = clusterMetadata.VCPUCount
+	metadata.MemoryMB = clusterMetadata.MemoryMB
+
+	return metadata, nil
+}
+
+// DeleteMembership deletes a membership GKEHub resource
+// If the membership does not exist the returned error wraps ErrMembershipNotFound.
+// The K8s artifacts are not deleted if the GKE cluster of the membership is gone
+func DeleteMembership(ctx context.Context, svc *Service, project string, location string, membershipID string, k8sAuth k8s.Auth, deleteArtifacts bool) error {
+	client, err := NewClient(svc, project, location, k8sAuth)
+	if err != nil {
+		return fmt.Errorf("Getting new client: %w", err)
+	}
+	// Get membership info
+	err = client.GetMembership(ctx, membershipID, false)
+	if err != nil {
+		return fmt.Errorf("Checking membership info: %w", err)
+	}
+
+	// GKE memberships are not reached through a kubeconfig
+	if client.Resource.Endpoint.GKECluster.ResourceLink != "" && deleteArtifacts {
+		k8sAuth, err = client.GetGKEClusterAuth(ctx, client.Resource.Endpoint.GKECluster.ResourceLink)
+		if errors.Is(err, ErrGKEClusterNotFound) {
+			// The artifacts went away with the cluster, the membership still has to go
+			debug.GoLog("DeleteMembership: GKE cluster " + client.Resource.Endpoint.GKECluster.ResourceLink + " not found, not deleting artifacts")
+			deleteArtifacts = false
+		} else if err != nil {
+			return fmt.Errorf("Getting GKE cluster credentials: %w", err)
+		}
+	}
+
+	// Delete the membership, this waits until the resource gets deleted
+	err = client.DeleteMembership(ctx)
+	if IsNotFound(err) {
+		return fmt.Errorf("%v: %w", membershipID, ErrMembershipNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("Deleting membership: %w", err)
 	}
 
 	// Delete K8s artifacts if deleteArtifacts is set to true
@@ -126,30 +282,94 @@ func DeleteMembership(project string, membershipID string, description string, g
 	return nil
 }
 
-// InstallOrUpdateConnectAgent retrieves the connect-agent manifests from the gke api
-// and installs or update them into a Kubernetes cluster
-func (ca ConnectAgent) InstallOrUpdateConnectAgent(project string, membershipID string, k8sAuth k8s.Auth) error {
-	client, err := NewClient(ctx, project, k8sAuth)
+// InstallOrUpdateConnectAgent retrieves the connect-agent manifests from the gke api,
+// installs or update them into a Kubernetes cluster and waits until the agent is ready.
+// On upgrades with UpdateGCPSAKey set, the credentials secret is replaced before waiting
+func (ca ConnectAgent) InstallOrUpdateConnectAgent(ctx context.Context, svc *Service, project string, location string, membershipID string, k8sAuth k8s.Auth) error {
+	client, err := NewClient(svc, project, location, k8sAuth)
 	if err != nil {
 		return fmt.Errorf("Getting new membership client: %w", err)
 	}
 
 	// Get membership info
-	err = client.GetMembership(membershipID, false)
+	err = client.GetMembership(ctx, membershipID, false)
 	if err != nil {
 		return fmt.Errorf("Checking membership info: %w", err)
 	}
 
 	// Call the api and get the manifests
-	ca.Response, err = client.GenerateConnectManifest(ca.Proxy, ca.Namespace, ca.Version, ca.IsUpgrade, ca.Registry, ca.ImagePullSecretContent)
+	ca.Response, err = client.GenerateConnectManifest(ctx, ca.Proxy, ca.Namespace, ca.Version, ca.IsUpgrade, ca.Registry, ca.ImagePullSecretContent)
 	if err != nil {
 		return fmt.Errorf("Generating connect-agent manifests: %w", err)
 	}
 
-	err = k8s.InstallOrUpdateGKEConnectAgent(ctx, k8sAuth, ca.Response, ca.GCPSAKey, ca.Namespace)
+	// On upgrades we want the whole configuration re-applied, not only version bumps
+	err = k8s.InstallOrUpdateGKEConnectAgent(ctx, k8sAuth, ca.Response, ca.GCPSAKey, ca.Namespace, ca.IsUpgrade)
 	if err != nil {
 		return fmt.Errorf("Calling InstallOrUpdateGKEConnectAgent: %w", err)
 	}
 
+	// Rotate the key before waiting, the agent will not get ready
+	// if the old one was revoked, the usual reason to replace it
+	if ca.IsUpgrade && ca.UpdateGCPSAKey {
+		err = ca.RotateGCPSAKey(ctx, k8sAuth)
+		if err != nil {
+			return fmt.Errorf("Rotating GCP SA key: %w", err)
+		}
+	}
+
+	// The agent is only useful once it is up and connected to the hub
+	err = k8s.WaitForGKEConnectAgent(ctx, k8sAuth, ca.Namespace)
+	if err != nil {
+		return fmt.Errorf("Calling WaitForGKEConnectAgent: %w", err)
+	}
+
+	return nil
+}
+
+// UninstallConnectAgent removes the connect-agent objects from a Kubernetes cluster.
+// The manifests are requested again to the gkehub API to find out the cluster scoped
+// objects; if the membership is already gone only the agent namespace is deleted
+func (ca ConnectAgent) UninstallConnectAgent(ctx context.Context, svc *Service, project string, location string, membershipID string, k8sAuth k8s.Auth) error {
+	client, err := NewClient(svc, project, location, k8sAuth)
+	if err != nil {
+		return fmt.Errorf("Getting new membership client: %w", err)
+	}
+
+	// Get membership info
+	err = client.GetMembership(ctx, membershipID, false)
+	if err != nil {
+		if !IsNotFound(err) {
+			return fmt.Errorf("Checking membership info: %w", err)
+		}
+		debug.GoLog("UninstallConnectAgent: membership " + membershipID + " not found, deleting only the agent namespace")
+	} else {
+		ca.Response, err = client.GenerateConnectManifest(ctx, ca.Proxy, ca.Namespace, ca.Version, false, ca.Registry, ca.ImagePullSecretContent)
+		if err != nil {
+			return fmt.Errorf("Generating connect-agent manifests: %w", err)
+		}
+	}
+
+	err = k8s.UninstallGKEConnectAgent(ctx, k8sAuth, ca.Response, ca.Namespace)
+	if err != nil {
+		return fmt.Errorf("Calling UninstallGKEConnectAgent: %w", err)
+	}
+
+	return nil
+}
+
+// RotateGCPSAKey replaces the connect-agent credentials secret and
+// restarts the agent so it starts using the new key
+func (ca ConnectAgent) RotateGCPSAKey(ctx context.Context, k8sAuth k8s.Auth) error {
+	err := k8s.UpdateGCPCredsSecret(ctx, k8sAuth, ca.GCPSAKey, ca.Namespace)
+	if err != nil {
+		return fmt.Errorf("Calling UpdateGCPCredsSecret: %w", err)
+	}
+
+	err = k8s.RestartGKEConnectAgent(ctx, k8sAuth, ca.Namespace)
+	if err != nil {
+		return fmt.Errorf("Calling RestartGKEConnectAgent: %w", err)
+	}
+
 	return nil
 }
@@ -1,51 +1,41 @@
 package hub
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/MayaraCloud/terraform-provider-anthos/debug"
-	"github.com/avast/retry-go"
 )
 
+// ErrMembershipNotFound is returned when the Hub does not know about a membership
+var ErrMembershipNotFound = errors.New("membership not found in the Hub")
+
+// ErrMembershipAlreadyExists is returned when a membership to be created is already in the Hub
+var ErrMembershipAlreadyExists = errors.New("membership already exists in the Hub")
+
 // GetMembership gets details of a hub membership.
 // This method also initializes/updates the client component
-func (c *Client) GetMembership(membershipID string, checkNotExisting bool) error {
+func (c *Client) GetMembership(ctx context.Context, membershipID string, checkNotExisting bool) error {
 	// Call the gkehub api
-	APIURL := prodAddr + "v1/projects/" + c.projectID + "/locations/" + c.location + "/memberships/" + membershipID
-	response, err := c.svc.client.Get(APIURL)
-	if err != nil {
-		return fmt.Errorf("get request: %w", err)
+	err := c.do(ctx, http.MethodGet, "v1/"+string(c.parentRef())+"/memberships/"+membershipID, nil, nil, &c.Resource)
+	if IsNotFound(err) {
+		// If we are checking if the resource does not exist
+		// we need a 404 here
+		if checkNotExisting {
+			return nil
+		}
+		return fmt.Errorf("%v: %w", membershipID, ErrMembershipNotFound)
 	}
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return fmt.Errorf("reading get request body: %w", err)
-	}
-
-	// If we are checking if the resource does not exist
-	// we need a 404 here
-	if checkNotExisting && response.StatusCode == 404 {
-		return nil
+		return err
 	}
 
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return fmt.Errorf("Bad %v status code: %v", response.StatusCode, string(body))
-	}
-
-	err = json.Unmarshal(body, &c.Resource)
-	if err != nil {
-		return fmt.Errorf("un-marshaling request body: %w", err)
-	}
-
-	if checkNotExisting && response.StatusCode != 404 {
-		return fmt.Errorf("The resource already exists in the Hub: %v", string(body))
+	if checkNotExisting {
+		return fmt.Errorf("%v: %w", membershipID, ErrMembershipAlreadyExists)
 	}
 
 	return nil
@@ -53,30 +43,26 @@ func (c *Client) GetMembership(membershipID string, checkNotExisting bool) error
 
 // CreateMembership creates a hub membership
 // The client object should already contain the
-// updated resource component updated in another method
-func (c *Client) CreateMembership(membershipID string) error {
+// updated resource component updated in another method.
+// The resource name is set as soon as the creation operation starts
+func (c *Client) CreateMembership(ctx context.Context, membershipID string) error {
 	// Validate exclusivity if the cluster has a manifest CRD present
 	if c.K8S.CRManifest != "" {
-		err := c.ValidateExclusivity(membershipID)
+		err := c.ValidateExclusivity(ctx, membershipID)
 		if err != nil {
 			return fmt.Errorf("Validating exclusivity: %w", err)
 		}
 	}
 	// Calling the creation API
-	createResponse, err := c.CallCreateMembershipAPI(membershipID)
+	operation, err := c.CallCreateMembershipAPI(ctx, membershipID)
 	if err != nil {
 		return fmt.Errorf("Calling CallCreateMembershipAPI: %w", err)
 	}
+	c.Resource.Name = string(c.parentRef()) + "/memberships/" + membershipID
 
-	// Wait until we get an ok from CheckOperation
-	retry.Attempts(60)
-	err = retry.Do(
-		func() error {
-			return c.CheckOperation(createResponse["name"].(string))
-		})
-
+	_, err = c.WaitOperation(ctx, operation.Name)
 	if err != nil {
-		return fmt.Errorf("Retry checking CreateMembership operation: %w", err)
+		return fmt.Errorf("Waiting for CreateMembership operation: %w", err)
 	}
 	return nil
 }
@@ -84,127 +70,152 @@ func (c *Client) CreateMembership(membershipID string) error {
 // CallCreateMembershipAPI creates a hub membership
 // The client object should already contain the
 // updated resource component updated in another method
-func (c *Client) CallCreateMembershipAPI(membershipID string) (HTTPResult, error) {
+func (c *Client) CallCreateMembershipAPI(ctx context.Context, membershipID string) (Operation, error) {
 	// Create the json POST request body
 	var rawBody struct {
-		Description string `json:"description"`
-		ExternalID  string `json:"externalId"`
+		Description string              `json:"description"`
+		ExternalID  string              `json:"externalId,omitempty"`
+		Labels      map[string]string   `json:"labels,omitempty"`
+		Endpoint    *MembershipEndpoint `json:"endpoint,omitempty"`
+		Authority   *Authority          `json:"authority,omitempty"`
 	}
 	rawBody.Description = c.Resource.Description
-	rawBody.ExternalID = c.K8S.UUID
-
-	body, err := json.Marshal(rawBody)
-	if err != nil {
-		return nil, fmt.Errorf("Marshaling create request body: %w", err)
+	rawBody.Labels = c.Resource.Labels
+	// The external ID defaults to the cluster UUID
+	rawBody.ExternalID = c.Resource.ExternalID
+	if rawBody.ExternalID == "" {
+		rawBody.ExternalID = c.K8S.UUID
 	}
-	// Create a url object to append parameters to it
-	APIURL := prodAddr + "v1/projects/" + c.projectID + "/locations/" + c.location + "/memberships"
-	u, err := url.Parse(APIURL)
-	if err != nil {
-		return nil, fmt.Errorf("Parsing %v url: %w", APIURL, err)
+	if c.Resource.Endpoint.GKECluster.ResourceLink != "" {
+		rawBody.Endpoint = &MembershipEndpoint{GKECluster: c.Resource.Endpoint.GKECluster}
 	}
-	q := u.Query()
-	q.Set("alt", "json")
-	q.Set("membershipId", membershipID)
-	u.RawQuery = q.Encode()
-	// Go ahead with the request
-	response, err := c.svc.client.Post(u.String(), "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return nil, fmt.Errorf("create POST request: %w", err)
+	if c.Resource.Authority.Issuer != "" {
+		rawBody.Authority = &Authority{Issuer: c.Resource.Authority.Issuer, OIDCJWKS: c.Resource.Authority.OIDCJWKS}
 	}
-	defer response.Body.Close()
 
-	return DecodeHTTPResult(response.Body)
+	var operation Operation
+	q := url.Values{}
+	q.Set("membershipId", membershipID)
+	err := c.do(ctx, http.MethodPost, "v1/"+string(c.parentRef())+"/memberships", q, rawBody, &operation)
+	return operation, err
 }
 
-// HTTPResult is used to store the result of an http request
-type HTTPResult map[string]interface{}
-
-// DecodeHTTPResult decodes an http response body
-func DecodeHTTPResult(httpBody io.ReadCloser) (HTTPResult, error) {
-	var h HTTPResult
-	err := json.NewDecoder(httpBody).Decode(&h)
-	if err != nil {
-		return nil, fmt.Errorf("Decoding http body response: %w", err)
+// UpdateMembership updates a hub membership fields listed in updateMask,
+// using the API field names (e.g. description, externalId).
+// The client object should already contain the
+// updated resource component updated in another method
+func (c *Client) UpdateMembership(ctx context.Context, updateMask []string) error {
+	// Create the json PATCH request body with only the fields to update
+	rawBody := make(map[string]interface{})
+	for _, field := range updateMask {
+		switch field {
+		case "description":
+			rawBody[field] = c.Resource.Description
+		case "externalId":
+			rawBody[field] = c.Resource.ExternalID
+		case "labels":
+			rawBody[field] = c.Resource.Labels
+		case "authority":
+			rawBody[field] = Authority{Issuer: c.Resource.Authority.Issuer, OIDCJWKS: c.Resource.Authority.OIDCJWKS}
+		default:
+			return fmt.Errorf("Unsupported update mask field: %v", field)
+		}
 	}
-	return h, nil
-}
 
-// CheckOperation checks a hub operation status and returns true if the operation is done
-func (c *Client) CheckOperation(operationName string) error {
-	// Create a url object to append parameters to it
-	APIURL := prodAddr + "v1/" + operationName
-	// Create the url parameters
-	u, err := url.Parse(APIURL)
+	var operation Operation
+	q := url.Values{}
+	q.Set("updateMask", strings.Join(updateMask, ","))
+	err := c.do(ctx, http.MethodPatch, "v1/"+c.Resource.Name, q, rawBody, &operation)
 	if err != nil {
-		return retry.Unrecoverable(fmt.Errorf("Parsing %v url: %w", APIURL, err))
+		return err
 	}
-	q := u.Query()
-	q.Set("alt", "json")
-	u.RawQuery = q.Encode()
-	// Go ahead with the request
-	response, err := c.svc.client.Get(u.String())
+
+	_, err = c.WaitOperation(ctx, operation.Name)
 	if err != nil {
-		return retry.Unrecoverable(fmt.Errorf("GET request: %w", err))
+		return fmt.Errorf("Waiting for UpdateMembership operation: %w", err)
 	}
-	defer response.Body.Close()
+	return nil
+}
 
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return retry.Unrecoverable(fmt.Errorf("Bad status code: %v", response.StatusCode))
+// adoptable tells if the membership in the client resource was registered with
+// externalID, e.g. by a creation that failed halfway, so it can be taken over
+// instead of created. An empty externalID means the client cluster UUID
+func (c *Client) adoptable(externalID string) bool {
+	if externalID == "" {
+		externalID = c.K8S.UUID
 	}
+	return externalID != "" &&
+		c.Resource.ExternalID == externalID &&
+		c.Resource.State.Code != MembershipStateDeleting
+}
 
-	result, err := DecodeHTTPResult(response.Body)
+// sameEndpoint tells if the membership in the client resource was registered for the
+// GKE cluster gkeClusterSelfLink, or for no GKE cluster if gkeClusterSelfLink is empty
+func (c *Client) sameEndpoint(gkeClusterSelfLink string) bool {
+	registered := c.Resource.Endpoint.GKECluster.ResourceLink
+	if registered == "" || gkeClusterSelfLink == "" {
+		return registered == gkeClusterSelfLink
+	}
+	registeredPath, err := GKEClusterPath(registered)
 	if err != nil {
-		return retry.Unrecoverable(fmt.Errorf("Calling DecodeHTTPResult: %w", err))
+		return false
 	}
+	configuredPath, err := GKEClusterPath(gkeClusterSelfLink)
+	return err == nil && registeredPath == configuredPath
+}
 
-	if result["done"] == true {
-		return nil
+// ValidateLocation checks that the client location is one of the
+// locations the gkehub API supports for the client project
+func (c *Client) ValidateLocation(ctx context.Context) error {
+	type locationsResponse struct {
+		Locations []struct {
+			LocationID string `json:"locationId"`
+		} `json:"locations"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+
+	var available []string
+	pageToken := ""
+	for {
+		q := url.Values{}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var result locationsResponse
+		err := c.do(ctx, http.MethodGet, "v1/projects/"+c.projectID+"/locations", q, nil, &result)
+		if err != nil {
+			return err
+		}
+		for _, location := range result.Locations {
+			if location.LocationID == c.location {
+				return nil
+			}
+			available = append(available, location.LocationID)
+		}
+
+		if result.NextPageToken == "" {
+			break
+		}
+		pageToken = result.NextPageToken
 	}
 
-	return fmt.Errorf("Failed to check operation: %v", result)
+	return fmt.Errorf("Location %v is not supported by the gkehub API, available locations are: %v", c.location, strings.Join(available, ", "))
 }
 
 // ValidateExclusivity checks the cluster exclusivity against the API
-func (c *Client) ValidateExclusivity(membershipID string) error {
-	// Call the gkehub api
-	APIURL := prodAddr + "v1beta1/projects/" + c.projectID + "/locations/" + c.location + "/memberships:validateExclusivity"
-	// Create the url parameters
-	u, err := url.Parse(APIURL)
-	if err != nil {
-		return fmt.Errorf("Parsing %v url: %w", APIURL, err)
-	}
-	q := u.Query()
+func (c *Client) ValidateExclusivity(ctx context.Context, membershipID string) error {
+	q := url.Values{}
 	q.Set("crManifest", c.K8S.CRManifest)
 	q.Set("intendedMembership", membershipID)
-	q.Set("alt", "json")
-	u.RawQuery = q.Encode()
-	// Go ahead with the request
-	response, err := c.svc.client.Get(u.String())
-	if err != nil {
-		return fmt.Errorf("get request: %w", err)
-	}
-	defer response.Body.Close()
-
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return fmt.Errorf("Bad status code: %v", response.Body)
-	}
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return fmt.Errorf("reading get request body: %w", err)
-	}
 	var result GRCPResponse
-	err = json.Unmarshal(body, &result)
+	err := c.do(ctx, http.MethodGet, "v1beta1/"+string(c.parentRef())+"/memberships:validateExclusivity", q, nil, &result)
 	if err != nil {
-		return fmt.Errorf("json Un-marshaling body: %w", err)
+		return err
 	}
 
 	// 0 == OK in gRCP codes, see below.
-	if result.Status.Code != 0 {
-		return fmt.Errorf("%v", result.Status.Message)
+	if result.Status.Code != CodeOK {
+		return statusAPIError(result.Status)
 	}
 
 	return nil
@@ -224,54 +235,25 @@ type GRCPResponseStatus struct {
 	// GenerateExclusivityManifest can successfully be applied.
 	// * ALREADY_EXISTS means that the Membership CRD is already owned by another
 	// Hub. See status.message for more information when this occurs
-	Code    int32                  `json:"code"`
-	Message string                 `json:"message"`
-	Details map[string]interface{} `json:"details"`
+	Code    int32                    `json:"code"`
+	Message string                   `json:"message"`
+	Details []map[string]interface{} `json:"details"`
 }
 
 // GenerateExclusivity checks the cluster exclusivity against the API
-func (c *Client) GenerateExclusivity(membershipID string) error {
-	// Call the gkehub api
-	APIURL := prodAddr + "v1beta1/projects/" + c.projectID + "/locations/" + c.location + "/memberships/" + membershipID + ":generateExclusivityManifest"
-
-	// Create the url parameters
-	u, err := url.Parse(APIURL)
-	if err != nil {
-		return fmt.Errorf("Parsing %v url: %w", APIURL, err)
-	}
-	q := u.Query()
-	q.Set("name", c.Resource.Name)
-	q.Set("crManifest", c.K8S.CRManifest)
-	q.Set("crdManifest", c.K8S.CRDManifest)
-	q.Set("alt", "json")
-	u.RawQuery = q.Encode()
-	// Go ahead with the request
-	response, err := c.svc.client.Get(u.String())
-	if err != nil {
-		return fmt.Errorf("get request: %w", err)
-	}
-	defer response.Body.Close()
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return fmt.Errorf("reading get request body: %w", err)
-	}
-
-	debug.GoLog("GenerateExclusivity: manifest response: " + string(body))
-
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return fmt.Errorf("Bad status code: %v", string(body))
-	}
-
+func (c *Client) GenerateExclusivity(ctx context.Context, membershipID string) error {
 	type manifestResponse struct {
 		CRDManifest string `json:"crdManifest"`
 		CRManifest  string `json:"crManifest"`
 	}
+	q := url.Values{}
+	q.Set("name", c.Resource.Name)
+	q.Set("crManifest", c.K8S.CRManifest)
+	q.Set("crdManifest", c.K8S.CRDManifest)
 	var result manifestResponse
-	err = json.Unmarshal(body, &result)
+	err := c.do(ctx, http.MethodGet, "v1beta1/"+string(c.parentRef())+"/memberships/"+membershipID+":generateExclusivityManifest", q, nil, &result)
 	if err != nil {
-		return fmt.Errorf("json Un-marshaling body: %w", err)
+		return err
 	}
 
 	// Populate the client with the manifest and CRD from the gkehub API
@@ -286,38 +268,18 @@ func (c *Client) GenerateExclusivity(membershipID string) error {
 
 // DeleteMembership deletes a hub membership
 // The client object should already contain the
-// updated resource component updated in another method
-func (c *Client) DeleteMembership() error {
-	// Delete a url object to append parameters to it
-	APIURL := prodAddr + "v1/" + c.Resource.Name
-
-	u, err := url.Parse(APIURL)
+// updated resource component updated in another method.
+// It waits until the delete operation is done
+func (c *Client) DeleteMembership(ctx context.Context) error {
+	var operation Operation
+	err := c.do(ctx, http.MethodDelete, "v1/"+c.Resource.Name, nil, nil, &operation)
 	if err != nil {
-		return fmt.Errorf("Parsing %v url: %w", APIURL, err)
+		return err
 	}
-	q := u.Query()
-	q.Set("alt", "json")
-	u.RawQuery = q.Encode()
-	// Go ahead with the request
-	req, err := http.NewRequest("DELETE", u.String(), nil)
-	if err != nil {
-		return fmt.Errorf("Creating Delete request: %w", err)
-	}
-	response, err := c.svc.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("Sending DELETE request: %w", err)
-	}
-	defer response.Body.Close()
 
-	body, err := ioutil.ReadAll(response.Body)
+	_, err = c.WaitOperation(ctx, operation.Name)
 	if err != nil {
-		return fmt.Errorf("reading get request body: %w", err)
-	}
-
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return fmt.Errorf("Bad %v status code: %v", response.StatusCode, string(body))
+		return fmt.Errorf("Waiting for DeleteMembership operation: %w", err)
 	}
-
 	return nil
 }
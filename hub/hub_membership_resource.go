@@ -1,24 +1,35 @@
 package hub
 
 import (
+	"regexp"
 	"time"
 )
 
+// MembershipFieldMaxLength is the maximum length of the membership ID, description and external ID
+const MembershipFieldMaxLength = 63
+
+// Regular expressions the membership fields must match, see the Resource fields documentation
+var (
+	MembershipIDRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	DescriptionRegexp  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_\-\. ]*$`)
+	ExternalIDRegexp   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_\-\.]*$`)
+)
+
 // Resource type contains specific info about a Hub membership resource
 type Resource struct {
 	// Output only. The unique name of this domain resource in the format:
-	// \n`projects\/[project_id]\/locations\/global\/memberships\/[membership_id]`.\n`membership_id`
+	// \n`projects\/[project_id]\/locations\/[location]\/memberships\/[membership_id]`.\n`membership_id`
 	// can only be set at creation time using the `membership_id`\nfield in
 	// the creation request. `membership_id` must be a valid RFC 1123\ncompliant
 	// DNS label. In particular, it must be:\n  1. At most 63 characters in length\n  2. It must consist of lower case alphanumeric characters or `-`\n  3. It must start and end with an alphanumeric character\nI.e. `membership_id` must match the regex:
 	// `[a-z0-9]([-a-z0-9]*[a-z0-9])?`\nwith at most 63 characters.
 	Name string `json:"name"`
 
-	// GCP labels for this membership."
-	Labels string `json:"labels"`
+	// GCP labels for this membership.
+	Labels map[string]string `json:"labels,omitempty"`
 
 	// Required. Description of this membership, limited to 63 characters.
-	// It must match the regex: `a-zA-Z0-9*`
+	// It must match the regex: `[a-zA-Z0-9][a-zA-Z0-9_\-\.\ ]*`
 	Description string `json:"description"`
 
 	Endpoint MembershipEndpoint `json:"endpoint"`
@@ -42,7 +53,8 @@ type Resource struct {
 
 	// An externally-generated and managed ID for this Membership.
 	// This ID may still be modified after creation but it is not
-	// recommended to do so. The ID must match the regex: `a-zA-Z0-9*`
+	// recommended to do so. It is limited to 63 characters and
+	// must match the regex: `[a-zA-Z0-9][a-zA-Z0-9_\-\.]*`
 	ExternalID string `json:"externalId"`
 
 	// Output only. For clusters using Connect, the timestamp
@@ -73,11 +85,76 @@ const (
 )
 
 // MembershipEndpoint contains a map with a membership's endpoint information
-// At the moment it only has gke options
 type MembershipEndpoint struct {
 	// If this Membership is a Kubernetes API server hosted on GKE, this is a
 	// self link to its GCP resource.
 	GKECluster GKECluster `json:"gkeCluster"`
+
+	// Output only. Useful Kubernetes-specific metadata.
+	KubernetesMetadata *KubernetesMetadata `json:"kubernetesMetadata,omitempty"`
+
+	// The in-cluster Kubernetes Resources that should be applied
+	// for a correctly registered cluster, in the steady state.
+	KubernetesResource *KubernetesResource `json:"kubernetesResource,omitempty"`
+}
+
+// KubernetesMetadata contains Kubernetes-specific information about a membership cluster
+type KubernetesMetadata struct {
+	// Output only. Kubernetes API server version string as reported by `/version`.
+	KubernetesAPIServerVersion string `json:"kubernetesApiServerVersion"`
+
+	// Output only. Node providerID as reported by the first node in the list of
+	// nodes on the Kubernetes endpoint. On Kubernetes platforms that support
+	// zero-node clusters (like GKE-on-GCP), the node_count will be zero and the
+	// node_provider_id will be empty.
+	NodeProviderID string `json:"nodeProviderId"`
+
+	// Output only. Node count as reported by Kubernetes nodes resources.
+	NodeCount int32 `json:"nodeCount"`
+
+	// Output only. vCPU count as reported by Kubernetes nodes resources.
+	VCPUCount int32 `json:"vcpuCount"`
+
+	// Output only. The total memory capacity as reported by the sum of all
+	// Kubernetes nodes resources, defined in MB.
+	MemoryMB int32 `json:"memoryMb"`
+
+	// Output only. The time at which these details were last updated. This
+	// update_time is different from the Membership-level update_time since
+	// EndpointDetails are updated internally for API consumers.
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+// KubernetesResource contains the Kubernetes resources installed for a membership
+type KubernetesResource struct {
+	// Output only. Additional Kubernetes resources that need to be applied to
+	// the cluster after Membership creation, and after every update.
+	MembershipResources []ResourceManifest `json:"membershipResources,omitempty"`
+
+	// Output only. The Kubernetes resources for installing the GKE Connect agent.
+	ConnectResources []ResourceManifest `json:"connectResources,omitempty"`
+
+	// Optional. Options for Kubernetes resource generation.
+	ResourceOptions ResourceOptions `json:"resourceOptions"`
+}
+
+// ResourceManifest represents a single Kubernetes resource to be applied to the cluster
+type ResourceManifest struct {
+	// YAML manifest of the resource.
+	Manifest string `json:"manifest"`
+
+	// Whether the resource provided in the manifest is `cluster_scoped`.
+	ClusterScoped bool `json:"clusterScoped"`
+}
+
+// ResourceOptions represents options for Kubernetes resource generation
+type ResourceOptions struct {
+	// Optional. The Connect agent version to use for connect_resources.
+	ConnectVersion string `json:"connectVersion,omitempty"`
+
+	// Optional. Use `apiextensions/v1beta1` instead of `apiextensions/v1`
+	// for CustomResourceDefinition resources.
+	V1Beta1CRD bool `json:"v1beta1Crd,omitempty"`
 }
 
 // GKECluster represents a k8s cluster on GKE.
@@ -98,11 +175,16 @@ type Authority struct {
 	// An JWT issuer URI.\nGoogle will attempt OIDC discovery on this URI,
 	// and allow valid OIDC tokens\nfrom this issuer to authenticate within
 	// the below identity namespace.
-	Issuer string `json:"Issuer"`
+	Issuer string `json:"issuer"`
+
+	// Optional. OIDC verification keys in JWKS format (RFC 7517). When set,
+	// Google uses them instead of fetching them from the issuer, which
+	// allows private issuers (e.g. on-prem or private EKS clusters)
+	OIDCJWKS []byte `json:"oidcJwks,omitempty"`
 
 	// Output only. The identity namespace in which the issuer will be recognized.
-	IdentityNamespace string `json:"identityNamespace"`
+	IdentityNamespace string `json:"identityNamespace,omitempty"`
 
 	// Output only. An identity provider that reflects this issuer in the identity namespace.
-	IdentityProvider string `json:"identityProvider"`
+	IdentityProvider string `json:"identityProvider,omitempty"`
 }
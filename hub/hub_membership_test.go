@@ -0,0 +1,54 @@
+package hub
+
+import "testing"
+
+func TestAdoptable(t *testing.T) {
+	cases := []struct {
+		name       string
+		uuid       string
+		registered string
+		state      stateString
+		externalID string
+		want       bool
+	}{
+		{name: "registered with the cluster UUID", uuid: "uuid", registered: "uuid", state: MembershipStateReady, want: true},
+		{name: "registered with the configured external ID", uuid: "uuid", registered: "my-id", state: MembershipStateReady, externalID: "my-id", want: true},
+		{name: "still creating", uuid: "uuid", registered: "uuid", state: MembershipStateCreating, want: true},
+		{name: "being deleted", uuid: "uuid", registered: "uuid", state: MembershipStateDeleting, want: false},
+		{name: "another cluster", uuid: "uuid", registered: "other-uuid", state: MembershipStateReady, want: false},
+		{name: "configured external ID differs", uuid: "uuid", registered: "uuid", state: MembershipStateReady, externalID: "my-id", want: false},
+		{name: "no external ID at all", state: MembershipStateReady, want: false},
+	}
+	for _, c := range cases {
+		client := &Client{K8S: K8S{UUID: c.uuid}}
+		client.Resource.ExternalID = c.registered
+		client.Resource.State.Code = c.state
+		if got := client.adoptable(c.externalID); got != c.want {
+			t.Errorf("%v: adoptable(%q) = %v, expected %v", c.name, c.externalID, got, c.want)
+		}
+	}
+}
+
+func TestSameEndpoint(t *testing.T) {
+	const link = "//container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/my-cluster"
+	cases := []struct {
+		registered string
+		configured string
+		want       bool
+	}{
+		{registered: "", configured: "", want: true},
+		{registered: link, configured: link, want: true},
+		{registered: link, configured: "//container.googleapis.com/projects/my-project/zones/us-west1-a/clusters/my-cluster", want: true},
+		{registered: link, configured: "", want: false},
+		{registered: "", configured: link, want: false},
+		{registered: link, configured: "//container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/other-cluster", want: false},
+		{registered: "not a link", configured: link, want: false},
+	}
+	for _, c := range cases {
+		client := &Client{}
+		client.Resource.Endpoint.GKECluster.ResourceLink = c.registered
+		if got := client.sameEndpoint(c.configured); got != c.want {
+			t.Errorf("sameEndpoint(%q) with %q registered = %v, expected %v", c.configured, c.registered, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
+)
+
+// Backoff applied between operation polls
+const (
+	operationPollInitialDelay = 1 * time.Second
+	operationPollMaxDelay     = 30 * time.Second
+	operationPollMultiplier   = 2
+)
+
+// Operation is a gkehub long-running operation
+// https://cloud.google.com/resource-manager/reference/rest/Shared.Types/Operation
+type Operation struct {
+	// Server-assigned name, e.g. projects/p/locations/global/operations/operation-123
+	Name string `json:"name"`
+
+	// If false the operation is still in progress, if true it
+	// finished and either Error or Response is available
+	Done bool `json:"done"`
+
+	// Error result of the operation in case of failure or cancellation
+	Error *GRCPResponseStatus `json:"error"`
+
+	// Normal response of the operation in case of success, e.g. a Membership
+	Response json.RawMessage `json:"response"`
+
+	// Service-specific metadata associated with the operation
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// OperationError is returned when a long-running operation finishes with an error status
+type OperationError struct {
+	Operation string
+	Status    GRCPResponseStatus
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("Operation %v failed with code %v: %v", e.Operation, e.Status.Code, e.Status.Message)
+}
+
+// Unwrap exposes the operation status as an *APIError, so IsNotFound and friends work on it
+func (e *OperationError) Unwrap() error {
+	return statusAPIError(e.Status)
+}
+
+// GetOperation gets the current status of a hub operation
+func (c *Client) GetOperation(ctx context.Context, operationName string) (Operation, error) {
+	var operation Operation
+	err := c.do(ctx, http.MethodGet, "v1/"+operationName, nil, nil, &operation)
+	return operation, err
+}
+
+// WaitOperation polls a hub operation with exponential backoff until it is done or ctx
+// is done. There is no timeout of its own, callers must bound ctx, e.g. with the resource
+// timeouts. It returns the operation response, or an *OperationError if the operation
+// finished with an error status
+func (c *Client) WaitOperation(ctx context.Context, operationName string) (json.RawMessage, error) {
+	if operationName == "" {
+		return nil, fmt.Errorf("The API response is not an operation")
+	}
+
+	delay := operationPollInitialDelay
+	for {
+		operation, err := c.GetOperation(ctx, operationName)
+		if err != nil {
+			return nil, fmt.Errorf("Getting operation %v: %w", operationName, err)
+		}
+		if operation.Done {
+			if operation.Error != nil {
+				return nil, &OperationError{Operation: operationName, Status: *operation.Error}
+			}
+			return operation.Response, nil
+		}
+
+		debug.GoLog("WaitOperation: " + operationName + " not done, waiting " + delay.String())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("Waiting for operation %v: %w", operationName, ctx.Err())
+		case <-timer.C:
+		}
+
+		delay *= operationPollMultiplier
+		if delay > operationPollMaxDelay {
+			delay = operationPollMaxDelay
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
+)
+
+// RetryPolicy controls how gkehub API requests failing with a transient
+// status code or a connection reset are retried. Zero fields take the DefaultRetryPolicy values
+type RetryPolicy struct {
+	// Total number of attempts, the first one included
+	Attempts uint
+	// Delay before the first retry, doubled on every further retry and jittered
+	Delay time.Duration
+	// Upper bound of the delay between retries
+	MaxDelay time.Duration
+	// HTTP status codes worth retrying
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy is the policy used when none is configured
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 5,
+	Delay:    1 * time.Second,
+	MaxDelay: 30 * time.Second,
+	RetryableStatusCodes: []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// withDefaults returns a copy of p with its zero fields set to the DefaultRetryPolicy ones
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts == 0 {
+		p.Attempts = DefaultRetryPolicy.Attempts
+	}
+	if p.Delay == 0 {
+		p.Delay = DefaultRetryPolicy.Delay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.RetryableStatusCodes == nil {
+		p.RetryableStatusCodes = DefaultRetryPolicy.RetryableStatusCodes
+	}
+	return p
+}
+
+// Validate checks the policy values are consistent
+func (p RetryPolicy) Validate() error {
+	if p.Delay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("Retry delays can not be negative")
+	}
+	if p.MaxDelay != 0 && p.Delay > p.MaxDelay {
+		return fmt.Errorf("Retry delay %v is greater than the max delay %v", p.Delay, p.MaxDelay)
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("Invalid retryable HTTP status code: %v", code)
+		}
+	}
+	return nil
+}
+
+// retryable tells if a response with statusCode should be retried
+func (p RetryPolicy) retryable(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotentMethods are the HTTP methods safe to repeat after a failure,
+// requests with other methods are only retried when they were rate limited
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// shouldRetry tells if a request attempt that got response or err is worth
+// another attempt, returning the reason to log
+func (p RetryPolicy) shouldRetry(method string, response *http.Response, err error) (bool, string) {
+	idempotent := idempotentMethods[method]
+	if err != nil {
+		// The request may have reached the server, only repeat it if that is harmless
+		return idempotent && isConnectionReset(err), err.Error()
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		return p.retryable(response.StatusCode), response.Status
+	}
+	return idempotent && p.retryable(response.StatusCode), response.Status
+}
+
+// isConnectionReset tells if err comes from the connection being dropped by the other end
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the jittered delay before retry number retry, starting at 1.
+// The delay doubles on every retry up to MaxDelay, and a random value of up
+// to half of it is subtracted so concurrent clients do not retry in lockstep
+func (p RetryPolicy) backoff(retry uint) time.Duration {
+	delay := p.Delay
+	for i := uint(1); i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay -= time.Duration(rand.Int63n(half))
+	}
+	return delay
+}
+
+// retryDelay returns the delay before retry number retry of a request that got response.
+// A Retry-After header replaces the backoff, as the server knows better when it will be
+// able to take the request, but it is capped to MaxDelay so it can not stall an apply
+func (p RetryPolicy) retryDelay(retry uint, response *http.Response) time.Duration {
+	delay := p.backoff(retry)
+	if after := retryAfter(response); after > 0 {
+		delay = after
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// retryAfter parses the Retry-After header of response, either
+// seconds or an HTTP date. It returns 0 if there is none
+func retryAfter(response *http.Response) time.Duration {
+	if response == nil {
+		return 0
+	}
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// send issues req with the client retry policy. Idempotent requests are retried on
+// the policy status codes and on connection resets, the others only when rate limited.
+// Requests are not retried past the req context deadline.
+// The request body is rewound between attempts, so req must have been created with http.NewRequest
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	policy := c.RetryPolicy.withDefaults()
+	for attempt := uint(1); ; attempt++ {
+		response, err := c.svc.client.Do(req)
+		retry, reason := policy.shouldRetry(req.Method, response, err)
+		if !retry || attempt >= policy.Attempts {
+			return response, err
+		}
+
+		delay := policy.retryDelay(attempt, response)
+		// The retry would be cancelled anyway, return the actual failure instead
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+			return response, err
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+		debug.GoLog(fmt.Sprintf("send: %v %v failed (%v), attempt %v of %v, retrying in %v", req.Method, req.URL.Path, reason, attempt, policy.Attempts, delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("Retrying %v %v: %w", req.Method, req.URL.Path, req.Context().Err())
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("Rewinding %v request body: %w", req.Method, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyWithDefaults(t *testing.T) {
+	p := RetryPolicy{}.withDefaults()
+	if p.Attempts != DefaultRetryPolicy.Attempts || p.Delay != DefaultRetryPolicy.Delay || p.MaxDelay != DefaultRetryPolicy.MaxDelay || len(p.RetryableStatusCodes) != len(DefaultRetryPolicy.RetryableStatusCodes) {
+		t.Errorf("RetryPolicy{}.withDefaults() = %+v, expected %+v", p, DefaultRetryPolicy)
+	}
+
+	// An explicitly empty status code list disables retries on status codes
+	p = RetryPolicy{Attempts: 2, RetryableStatusCodes: []int{}}.withDefaults()
+	if p.Attempts != 2 || len(p.RetryableStatusCodes) != 0 {
+		t.Errorf("withDefaults() overrode the set fields: %+v", p)
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy  RetryPolicy
+		wantErr bool
+	}{
+		{policy: RetryPolicy{}},
+		{policy: DefaultRetryPolicy},
+		{policy: RetryPolicy{Delay: 5 * time.Second}},
+		{policy: RetryPolicy{Delay: -time.Second}, wantErr: true},
+		{policy: RetryPolicy{MaxDelay: -time.Second}, wantErr: true},
+		{policy: RetryPolicy{Delay: time.Minute, MaxDelay: time.Second}, wantErr: true},
+		{policy: RetryPolicy{RetryableStatusCodes: []int{99}}, wantErr: true},
+		{policy: RetryPolicy{RetryableStatusCodes: []int{600}}, wantErr: true},
+	}
+	for _, c := range cases {
+		err := c.policy.Validate()
+		if (err != nil) != c.wantErr {
+			t.Errorf("%+v.Validate() = %v, expected error: %v", c.policy, err, c.wantErr)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	p := DefaultRetryPolicy
+	reset := &url.Error{Op: "Get", URL: "https://gkehub.googleapis.com/", Err: syscall.ECONNRESET}
+	cases := []struct {
+		method     string
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{method: http.MethodGet, statusCode: http.StatusOK, want: false},
+		{method: http.MethodGet, statusCode: http.StatusNotFound, want: false},
+		{method: http.MethodGet, statusCode: http.StatusServiceUnavailable, want: true},
+		{method: http.MethodDelete, statusCode: http.StatusInternalServerError, want: true},
+		{method: http.MethodGet, statusCode: http.StatusTooManyRequests, want: true},
+		// Non idempotent requests may have been applied, only retry them when rate limited
+		{method: http.MethodPost, statusCode: http.StatusServiceUnavailable, want: false},
+		{method: http.MethodPatch, statusCode: http.StatusBadGateway, want: false},
+		{method: http.MethodPost, statusCode: http.StatusTooManyRequests, want: true},
+		{method: http.MethodGet, err: reset, want: true},
+		{method: http.MethodPost, err: reset, want: false},
+		{method: http.MethodGet, err: errors.New("x509: certificate signed by unknown authority"), want: false},
+	}
+	for _, c := range cases {
+		var response *http.Response
+		if c.err == nil {
+			response = &http.Response{StatusCode: c.statusCode, Status: strconv.Itoa(c.statusCode)}
+		}
+		got, reason := p.shouldRetry(c.method, response, c.err)
+		if got != c.want {
+			t.Errorf("shouldRetry(%v, %v, %v) = %v, expected %v", c.method, c.statusCode, c.err, got, c.want)
+		}
+		if reason == "" {
+			t.Errorf("shouldRetry(%v, %v, %v) returned no reason", c.method, c.statusCode, c.err)
+		}
+	}
+
+	// Rate limited requests follow the policy status codes too
+	noRetries := RetryPolicy{RetryableStatusCodes: []int{}}
+	if got, _ := noRetries.shouldRetry(http.MethodPost, &http.Response{StatusCode: http.StatusTooManyRequests}, nil); got {
+		t.Errorf("shouldRetry() retried a rate limited request without 429 in the policy")
+	}
+}
+
+func TestIsConnectionReset(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{err: syscall.ECONNRESET, want: true},
+		{err: fmt.Errorf("write: %w", syscall.EPIPE), want: true},
+		{err: &url.Error{Op: "Get", Err: io.ErrUnexpectedEOF}, want: true},
+		{err: io.EOF, want: false},
+		{err: syscall.ECONNREFUSED, want: false},
+		{err: errors.New("connection reset"), want: false},
+	}
+	for _, c := range cases {
+		if got := isConnectionReset(c.err); got != c.want {
+			t.Errorf("isConnectionReset(%v) = %v, expected %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Delay: time.Second, MaxDelay: 10 * time.Second}
+	cases := []struct {
+		retry uint
+		max   time.Duration
+	}{
+		{retry: 1, max: time.Second},
+		{retry: 2, max: 2 * time.Second},
+		{retry: 3, max: 4 * time.Second},
+		{retry: 4, max: 8 * time.Second},
+		{retry: 5, max: 10 * time.Second},
+		{retry: 100, max: 10 * time.Second},
+	}
+	for _, c := range cases {
+		// The jitter takes away up to half of the delay
+		for i := 0; i < 50; i++ {
+			got := p.backoff(c.retry)
+			if got > c.max || got < c.max/2 {
+				t.Fatalf("backoff(%v) = %v, expected between %v and %v", c.retry, got, c.max/2, c.max)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	withHeader := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+	cases := []struct {
+		name     string
+		response *http.Response
+		min, max time.Duration
+	}{
+		{name: "no response"},
+		{name: "no header", response: &http.Response{Header: http.Header{}}},
+		{name: "seconds", response: withHeader("7"), min: 7 * time.Second, max: 7 * time.Second},
+		{name: "zero seconds", response: withHeader("0")},
+		{name: "negative seconds", response: withHeader("-3")},
+		{name: "garbage", response: withHeader("soon")},
+		{name: "HTTP date", response: withHeader(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)), min: 58 * time.Second, max: time.Minute},
+	}
+	for _, c := range cases {
+		got := retryAfter(c.response)
+		if got < c.min || got > c.max {
+			t.Errorf("%v: retryAfter() = %v, expected between %v and %v", c.name, got, c.min, c.max)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Delay: time.Second, MaxDelay: 30 * time.Second}
+	withHeader := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+	cases := []struct {
+		name     string
+		response *http.Response
+		min, max time.Duration
+	}{
+		{name: "backoff", response: &http.Response{Header: http.Header{}}, min: 500 * time.Millisecond, max: time.Second},
+		{name: "Retry-After replaces the backoff", response: withHeader("20"), min: 20 * time.Second, max: 20 * time.Second},
+		{name: "Retry-After capped to MaxDelay", response: withHeader("3600"), min: 30 * time.Second, max: 30 * time.Second},
+		{name: "HTTP date capped to MaxDelay", response: withHeader(time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat)), min: 30 * time.Second, max: 30 * time.Second},
+	}
+	for _, c := range cases {
+		got := p.retryDelay(1, c.response)
+		if got < c.min || got > c.max {
+			t.Errorf("%v: retryDelay() = %v, expected between %v and %v", c.name, got, c.min, c.max)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		timeout  time.Duration
+		failures int
+		header   string
+		attempts int
+		status   int
+	}{
+		{name: "transient failures", method: http.MethodGet, failures: 2, attempts: 3, status: http.StatusOK},
+		{name: "out of attempts", method: http.MethodGet, failures: 5, attempts: 3, status: http.StatusServiceUnavailable},
+		{name: "non idempotent", method: http.MethodPost, failures: 1, attempts: 1, status: http.StatusServiceUnavailable},
+		{name: "retry past the deadline", method: http.MethodGet, timeout: time.Second, failures: 1, header: "60", attempts: 1, status: http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		hits := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits++
+			if hits <= c.failures {
+				if c.header != "" {
+					w.Header().Set("Retry-After", c.header)
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		client := &Client{
+			svc:         &Service{client: server.Client()},
+			RetryPolicy: RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Minute},
+		}
+
+		ctx := context.Background()
+		if c.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+		}
+		req, err := http.NewRequestWithContext(ctx, c.method, server.URL, nil)
+		if err != nil {
+			t.Fatalf("%v: creating request: %v", c.name, err)
+		}
+		start := time.Now()
+		response, err := client.send(req)
+		if err != nil {
+			t.Errorf("%v: send() unexpected error: %v", c.name, err)
+		} else {
+			response.Body.Close()
+			if response.StatusCode != c.status {
+				t.Errorf("%v: send() returned status %v, expected %v", c.name, response.StatusCode, c.status)
+			}
+		}
+		if hits != c.attempts {
+			t.Errorf("%v: send() made %v attempts, expected %v", c.name, hits, c.attempts)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%v: send() took %v", c.name, elapsed)
+		}
+		server.Close()
+	}
+}
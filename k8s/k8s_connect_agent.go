@@ -4,18 +4,23 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/scheme"
 )
 
 // InstallOrUpdateGKEConnectAgent installs or update a gke-connect agent in a Kubernetes cluster
+// Existing objects are only updated when their version label changes, unless forceUpdate is set
 // TODO: try to simplify the whole thing using restMapper and dynamic client
-func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResponse ConnectManifestResponse, GCPSAKey string, namespace string) error {
+func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResponse ConnectManifestResponse, GCPSAKey string, namespace string, forceUpdate bool) error {
 	kubeClient, err := KubeClientSet(auth)
 	if err != nil {
 		return fmt.Errorf("Initializing Kube clientset: %w", err)
@@ -66,7 +71,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting namespace: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if namespace.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || namespace.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.CoreV1().Namespaces().Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("Updating namespace %v, error was %w", manifest.Manifest, err)
@@ -84,7 +89,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting service account: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if sa.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || sa.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.CoreV1().ServiceAccounts(o.Namespace).Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("Updating service account %v, error was %w", manifest.Manifest, err)
@@ -102,7 +107,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting role: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if role.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || role.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.RbacV1().Roles(o.Namespace).Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("Updating role %v, error was %w", manifest.Manifest, err)
@@ -120,7 +125,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting role binding: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if roleBinding.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || roleBinding.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.RbacV1().RoleBindings(o.Namespace).Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("Updating role binding %v, error was %w", manifest.Manifest, err)
@@ -138,7 +143,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting cluster role: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if clusterRole.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || clusterRole.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.RbacV1().ClusterRoles().Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("Updating cluster role %v, error was %w", manifest.Manifest, err)
@@ -156,7 +161,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting cluster role binding: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if clusterRoleBinding.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || clusterRoleBinding.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.RbacV1().ClusterRoleBindings().Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("Updating cluster role binding %v, error was %w", manifest.Manifest, err)
@@ -174,7 +179,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting service: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if service.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || service.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.CoreV1().Services(o.Namespace).Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					if errors.IsInvalid(err) {
@@ -203,7 +208,7 @@ func InstallOrUpdateGKEConnectAgent(ctx context.Context, auth Auth, manifestResp
 					return fmt.Errorf("Getting deployment: %v, error was %w", manifest.Manifest, err)
 				}
 			}
-			if deployment.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
+			if forceUpdate || deployment.ObjectMeta.Labels["version"] != o.ObjectMeta.Labels["version"] {
 				_, err = kubeClient.AppsV1().Deployments(o.Namespace).Update(ctx, o, metav1.UpdateOptions{})
 				if err != nil {
 					if errors.IsInvalid(err) {
@@ -264,8 +269,219 @@ type ConnectAgentResourceType struct {
 func CreateGCPCredsSecret(GCPSAKey string, namespace string) v1.Secret {
 	var secret v1.Secret
 	secret.Data = make(map[string][]byte)
-	secret.Name = "creds-gcp"
+	secret.Name = ConnectAgentCredsName
 	secret.Namespace = namespace
-	secret.Data["creds-gcp"] = []byte(GCPSAKey)
+	secret.Data[ConnectAgentCredsName] = []byte(GCPSAKey)
 	return secret
 }
+
+// Names and labels of the objects that make up a gke-connect agent installation
+const (
+	ConnectAgentAppLabel        string = "gke-connect-agent"
+	ConnectAgentCredsName              = "creds-gcp"
+	ConnectAgentVersionKey             = "version"
+	ConnectAgentProxyEnv               = "HTTPS_PROXY"
+	ConnectAgentDefaultRegistry        = "gcr.io/gkeconnect"
+)
+
+// connectAgentPollInterval is how often the agent objects are checked while waiting on them
+const connectAgentPollInterval = 2 * time.Second
+
+// ConnectAgentStatus describes the gke-connect agent found in a Kubernetes cluster
+type ConnectAgentStatus struct {
+	Installed      bool   // the namespace and the agent deployment exist
+	DeploymentName string // name of the agent deployment
+	Version        string // value of the deployment "version" label, or the namespace one if missing
+	Ready          bool   // the agent rollout is complete and all the desired replicas are available
+	GCPSAKey       string // contents of the creds-gcp secret, empty if missing
+	Proxy          string // proxy configured in the agent container, if any
+	Registry       string // registry the agent image is pulled from
+}
+
+// GetGKEConnectAgentStatus inspects a Kubernetes cluster looking for a gke-connect agent
+// installed in namespace. A missing namespace or deployment is not an error,
+// the returned status will just have Installed set to false
+func GetGKEConnectAgentStatus(ctx context.Context, auth Auth, namespace string) (ConnectAgentStatus, error) {
+	var status ConnectAgentStatus
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return status, fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+
+	agentNamespace, err := kubeClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return status, nil
+		}
+		return status, fmt.Errorf("Getting namespace %v: %w", namespace, err)
+	}
+
+	deployments, err := kubeClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + ConnectAgentAppLabel})
+	if err != nil {
+		return status, fmt.Errorf("Listing deployments in namespace %v: %w", namespace, err)
+	}
+	if len(deployments.Items) == 0 {
+		return status, nil
+	}
+	deployment := deployments.Items[0]
+	status.Installed = true
+	status.DeploymentName = deployment.Name
+	status.Version = deployment.ObjectMeta.Labels[ConnectAgentVersionKey]
+	if status.Version == "" {
+		status.Version = agentNamespace.ObjectMeta.Labels[ConnectAgentVersionKey]
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		// The image looks like {registry}/{image}:{version}
+		if i := strings.LastIndex(container.Image, "/"); i > 0 {
+			status.Registry = container.Image[:i]
+		}
+		for _, env := range container.Env {
+			if env.Name == ConnectAgentProxyEnv {
+				status.Proxy = env.Value
+			}
+		}
+	}
+	desiredReplicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desiredReplicas = *deployment.Spec.Replicas
+	}
+	// Same conditions as kubectl rollout status: the controller has seen the
+	// latest spec and all the replicas run it and are available
+	status.Ready = deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == desiredReplicas &&
+		deployment.Status.AvailableReplicas == desiredReplicas &&
+		deployment.Status.ReadyReplicas >= desiredReplicas
+
+	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(ctx, ConnectAgentCredsName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return status, nil
+		}
+		return status, fmt.Errorf("Getting secret %v: %w", ConnectAgentCredsName, err)
+	}
+	status.GCPSAKey = string(secret.Data[ConnectAgentCredsName])
+
+	return status, nil
+}
+
+// UninstallGKEConnectAgent removes a gke-connect agent from a Kubernetes cluster.
+// Cluster scoped objects listed in manifestResponse are deleted one by one, the
+// namespaced ones are removed together with the agent namespace.
+// Objects that are already missing are ignored. The namespace termination
+// is waited for until ctx is done
+func UninstallGKEConnectAgent(ctx context.Context, auth Auth, manifestResponse ConnectManifestResponse, namespace string) error {
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+
+	for _, manifest := range manifestResponse.Manifest {
+		decode := scheme.Codecs.UniversalDeserializer().Decode
+		obj, _, err := decode([]byte(manifest.Manifest), nil, nil)
+		if err != nil {
+			// The empty creds Secret manifest can not be decoded, it lives in the namespace anyway
+			continue
+		}
+
+		switch o := obj.(type) {
+		case *rbacv1.ClusterRole:
+			debug.GoLog("UninstallGKEConnectAgent: deleting cluster role " + o.Name)
+			err = kubeClient.RbacV1().ClusterRoles().Delete(ctx, o.Name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Deleting cluster role %v: %w", o.Name, err)
+			}
+		case *rbacv1.ClusterRoleBinding:
+			debug.GoLog("UninstallGKEConnectAgent: deleting cluster role binding " + o.Name)
+			err = kubeClient.RbacV1().ClusterRoleBindings().Delete(ctx, o.Name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Deleting cluster role binding %v: %w", o.Name, err)
+			}
+		}
+	}
+
+	debug.GoLog("UninstallGKEConnectAgent: deleting namespace " + namespace)
+	err = kubeClient.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("Deleting namespace %v: %w", namespace, err)
+	}
+
+	// Namespace deletion is asynchronous, wait until all its objects are gone
+	err = wait.PollImmediateUntil(connectAgentPollInterval, func() (bool, error) {
+		_, err := kubeClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return false, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("Waiting for namespace %v termination: %w", namespace, err)
+	}
+
+	return nil
+}
+
+// WaitForGKEConnectAgent waits until the gke-connect agent installed in namespace
+// has all its replicas ready, or ctx is done
+func WaitForGKEConnectAgent(ctx context.Context, auth Auth, namespace string) error {
+	err := wait.PollImmediateUntil(connectAgentPollInterval, func() (bool, error) {
+		status, err := GetGKEConnectAgentStatus(ctx, auth, namespace)
+		if err != nil {
+			return false, err
+		}
+		debug.GoLog(fmt.Sprintf("WaitForGKEConnectAgent: installed %v, ready %v", status.Installed, status.Ready))
+		return status.Installed && status.Ready, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("Waiting for the agent in namespace %v to be ready: %w", namespace, err)
+	}
+	return nil
+}
+
+// UpdateGCPCredsSecret creates or replaces the creds-gcp secret used by the gke-connect agent
+func UpdateGCPCredsSecret(ctx context.Context, auth Auth, GCPSAKey string, namespace string) error {
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+	o := CreateGCPCredsSecret(GCPSAKey, namespace)
+	_, err = kubeClient.CoreV1().Secrets(namespace).Update(ctx, &o, metav1.UpdateOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			_, err = kubeClient.CoreV1().Secrets(namespace).Create(ctx, &o, metav1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("Creating secret %v: %w", o.Name, err)
+			}
+			return nil
+		}
+		return fmt.Errorf("Updating secret %v: %w", o.Name, err)
+	}
+	return nil
+}
+
+// RestartGKEConnectAgent triggers a rollout of the gke-connect agent deployment,
+// the same way kubectl rollout restart does, so the pods pick up new secrets
+func RestartGKEConnectAgent(ctx context.Context, auth Auth, namespace string) error {
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+	deployments, err := kubeClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + ConnectAgentAppLabel})
+	if err != nil {
+		return fmt.Errorf("Listing deployments in namespace %v: %w", namespace, err)
+	}
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%v"}}}}}`, time.Now().Format(time.RFC3339))
+	for _, deployment := range deployments.Items {
+		debug.GoLog("RestartGKEConnectAgent: restarting deployment " + deployment.Name)
+		_, err = kubeClient.AppsV1().Deployments(namespace).Patch(ctx, deployment.Name, k8sTypes.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("Restarting deployment %v: %w", deployment.Name, err)
+		}
+	}
+	return nil
+}
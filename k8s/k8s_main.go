@@ -2,31 +2,58 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"golang.org/x/oauth2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp" // This is needed for gcp auth
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Auth contains authentication info for kubernetes
 type Auth struct {
-	KubeConfigFile string
-	KubeContext    string
+	KubeConfigFile string // defaults to ~/.kube/config if empty
+	KubeContext    string // empty or "current" means the kubeconfig current context
+
+	// If Host is set the kubeconfig is ignored and the API server
+	// is reached directly, e.g. for GKE clusters
+	Host        string             // API server URL
+	CAData      []byte             // PEM encoded cluster CA certificate
+	TokenSource oauth2.TokenSource // bearer tokens for the API server
 }
 
 // KubeClientSet initializes the kubernetes API client
 func KubeClientSet(auth Auth) (*kubernetes.Clientset, error) {
+	if auth.Host != "" {
+		restConfig := &rest.Config{
+			Host: auth.Host,
+			TLSClientConfig: rest.TLSClientConfig{
+				CAData: auth.CAData,
+			},
+			WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+				return &oauth2.Transport{Source: auth.TokenSource, Base: rt}
+			},
+		}
+		return kubernetes.NewForConfig(restConfig)
+	}
+
 	kubeConfig := auth.KubeConfigFile
 	kubeContext := auth.KubeContext
 
-	if home := homeDir(); home != "" && kubeConfig == "" {
+	if kubeConfig == "" {
+		home := homeDir()
+		if home == "" {
+			return nil, fmt.Errorf("Homedir not found and no explicit config path provided")
+		}
 		kubeConfig = filepath.Join(home, ".kube", "config")
-	} else {
-		return nil, fmt.Errorf("Homedir not found and no explicit config path provided")
 	}
 
 	// use the current context in kubeconfig
@@ -37,7 +64,7 @@ func KubeClientSet(auth Auth) (*kubernetes.Clientset, error) {
 	// TODO it would be good to set proper config overrides
 	configOverrides := &clientcmd.ConfigOverrides{}
 	var clientConfig clientcmd.ClientConfig
-	if kubeConfig == "current" {
+	if kubeContext == "" || kubeContext == "current" {
 		clientConfig = clientcmd.NewDefaultClientConfig(*config, configOverrides)
 	} else {
 		clientConfig = clientcmd.NewNonInteractiveClientConfig(*config, kubeContext, configOverrides, nil)
@@ -77,3 +104,95 @@ func GetK8sClusterUUID(ctx context.Context, auth Auth) (string, error) {
 
 	return string(namespace.GetUID()), nil
 }
+
+// OIDCDiscoveryAbspath is the service account issuer discovery document path
+const OIDCDiscoveryAbspath string = "/.well-known/openid-configuration"
+
+// GetOIDCIssuer returns the service account token issuer of the cluster,
+// as published in its OIDC discovery document
+func GetOIDCIssuer(ctx context.Context, auth Auth) (string, error) {
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return "", fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+	object, err := kubeClient.RESTClient().Get().AbsPath(OIDCDiscoveryAbspath).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Getting %v: %w", OIDCDiscoveryAbspath, err)
+	}
+	var discovery struct {
+		Issuer string `json:"issuer"`
+	}
+	err = json.Unmarshal(object, &discovery)
+	if err != nil {
+		return "", fmt.Errorf("Un-marshaling OIDC discovery document: %w", err)
+	}
+	if discovery.Issuer == "" {
+		return "", fmt.Errorf("The OIDC discovery document has no issuer")
+	}
+	return discovery.Issuer, nil
+}
+
+// OIDCJWKSAbspath is the path where the API server publishes its service account signing keys
+const OIDCJWKSAbspath string = "/openid/v1/jwks"
+
+// GetOIDCJWKS returns the JWKS the cluster uses to sign service account tokens
+func GetOIDCJWKS(ctx context.Context, auth Auth) ([]byte, error) {
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return nil, fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+	object, err := kubeClient.RESTClient().Get().AbsPath(OIDCJWKSAbspath).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Getting %v: %w", OIDCJWKSAbspath, err)
+	}
+	return object, nil
+}
+
+// ClusterMetadata contains inventory information about a Kubernetes cluster
+type ClusterMetadata struct {
+	ServerVersion  string // API server version as reported by /version
+	NodeProviderID string // provider of the first node, e.g. aws or gce
+	NodeCount      int32
+	VCPUCount      int32 // sum of the nodes cpu capacity
+	MemoryMB       int32 // sum of the nodes memory capacity
+}
+
+// GetClusterMetadata collects the cluster version and its nodes capacity
+func GetClusterMetadata(ctx context.Context, auth Auth) (ClusterMetadata, error) {
+	var metadata ClusterMetadata
+	kubeClient, err := KubeClientSet(auth)
+	if err != nil {
+		return metadata, fmt.Errorf("Initializing Kube clientset: %w", err)
+	}
+
+	// Discovery().ServerVersion() does not take a context, request /version directly
+	body, err := kubeClient.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return metadata, fmt.Errorf("Getting server version: %w", err)
+	}
+	var serverVersion version.Info
+	err = json.Unmarshal(body, &serverVersion)
+	if err != nil {
+		return metadata, fmt.Errorf("json Un-marshaling server version: %w", err)
+	}
+	metadata.ServerVersion = serverVersion.GitVersion
+
+	nodes, err := kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return metadata, fmt.Errorf("Listing nodes: %w", err)
+	}
+	metadata.NodeCount = int32(len(nodes.Items))
+	if len(nodes.Items) > 0 {
+		// The provider ID looks like {provider}://{provider specific node id}
+		metadata.NodeProviderID = strings.SplitN(nodes.Items[0].Spec.ProviderID, "://", 2)[0]
+	}
+	var milliCPU, memoryBytes int64
+	for _, node := range nodes.Items {
+		milliCPU += node.Status.Capacity.Cpu().MilliValue()
+		memoryBytes += node.Status.Capacity.Memory().Value()
+	}
+	metadata.VCPUCount = int32(milliCPU / 1000)
+	metadata.MemoryMB = int32(memoryBytes / (1024 * 1024))
+
+	return metadata, nil
+}
@@ -4,8 +4,7 @@ import (
 	"os"
 
 	"github.com/MayaraCloud/terraform-provider-anthos/debug"
-	"github.com/hashicorp/terraform-plugin-sdk/plugin"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 )
 
 func main() {
@@ -13,8 +12,6 @@ func main() {
 		debug.DebugMode = true
 	}
 	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: func() terraform.ResourceProvider {
-			return Provider()
-		},
+		ProviderFunc: Provider,
 	})
 }
@@ -1,15 +1,173 @@
 package main
 
 import (
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MayaraCloud/terraform-provider-anthos/hub"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 // Provider returns the map of Terraform resources
 func Provider() *schema.Provider {
-	return &schema.Provider{
+	provider := &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"credentials": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"GOOGLE_CREDENTIALS",
+					"GOOGLE_CLOUD_KEYFILE_JSON",
+				}, nil),
+				Description:   "Path to or contents of a GCP JSON credentials file. Application Default Credentials are used if not set",
+				ConflictsWith: []string{"access_token"},
+			},
+			"access_token": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				DefaultFunc:   schema.EnvDefaultFunc("GOOGLE_OAUTH_ACCESS_TOKEN", nil),
+				Description:   "GCP OAuth2 access token, used instead of credentials",
+				ConflictsWith: []string{"credentials"},
+			},
+			"project": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{
+					"GOOGLE_PROJECT",
+					"GOOGLE_CLOUD_PROJECT",
+				}, nil),
+				Description: "Default GCP project id for the resources that do not set one",
+			},
+			"impersonate_service_account": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", nil),
+				Description: "GCP service account to impersonate for all the API calls",
+			},
+			"k8s_config_file": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("KUBE_CONFIG_PATH", nil),
+				Description: "Default Kubernetes credentials file, ~/.kube/config if not set",
+			},
+			"k8s_context": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("KUBE_CTX", nil),
+				Description: "Default Kubernetes context to use, the current one if not set",
+			},
+			"hub_endpoint": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(hub.EndpointEnvVar, nil),
+				Description: "Base URL of the gkehub API, e.g. a Private Service Connect or a test endpoint. Defaults to https://gkehub.googleapis.com/",
+			},
+			"retry": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "How gkehub API requests failing with a transient error are retried. Only idempotent requests are retried, except on 429 responses",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"attempts": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      int(hub.DefaultRetryPolicy.Attempts),
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "Total number of attempts of a request, the first one included",
+						},
+						"delay": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      hub.DefaultRetryPolicy.Delay.String(),
+							ValidateFunc: validateDuration,
+							Description:  "Delay before the first retry, doubled and jittered on every further retry, e.g. 1s. A Retry-After response header takes precedence",
+						},
+						"max_delay": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      hub.DefaultRetryPolicy.MaxDelay.String(),
+							ValidateFunc: validateDuration,
+							Description:  "Upper bound of the delay between retries, e.g. 30s",
+						},
+						"retryable_status_codes": &schema.Schema{
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "HTTP status codes worth retrying. Defaults to 429, 500, 502, 503 and 504",
+							Elem: &schema.Schema{
+								Type:         schema.TypeInt,
+								ValidateFunc: validation.IntBetween(100, 599),
+							},
+						},
+					},
+				},
+			},
+		},
 		ResourcesMap: map[string]*schema.Resource{
 			"anthos_cluster_membership": resourceMembership(),
 			"anthos_gke_connect_agent":  resourceGkeConnectAgent(),
 		},
 	}
+
+	provider.ConfigureContextFunc = providerConfigure
+
+	return provider
+}
+
+// providerConfigure builds the Config shared by all the resources
+func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	config := Config{
+		Credentials:               d.Get("credentials").(string),
+		AccessToken:               d.Get("access_token").(string),
+		Project:                   d.Get("project").(string),
+		ImpersonateServiceAccount: d.Get("impersonate_service_account").(string),
+		KubeConfigFile:            d.Get("k8s_config_file").(string),
+		KubeContext:               d.Get("k8s_context").(string),
+		HubEndpoint:               d.Get("hub_endpoint").(string),
+		RetryPolicy:               expandRetryPolicy(d),
+	}
+
+	// ctx only lives during the configuration, the stop context lives as
+	// long as the provider, so token refreshes keep working
+	stopCtx, ok := schema.StopContext(ctx)
+	if !ok {
+		stopCtx = ctx
+	}
+	if err := config.loadAndValidate(stopCtx); err != nil {
+		return nil, diag.FromErr(err)
+	}
+
+	return &config, nil
+}
+
+// expandRetryPolicy reads the provider retry block, unset values
+// are left empty so the hub defaults apply
+func expandRetryPolicy(d *schema.ResourceData) hub.RetryPolicy {
+	var policy hub.RetryPolicy
+	retry := d.Get("retry").([]interface{})
+	if len(retry) == 0 || retry[0] == nil {
+		return policy
+	}
+	r := retry[0].(map[string]interface{})
+	policy.Attempts = uint(r["attempts"].(int))
+	// Durations are already validated
+	policy.Delay, _ = time.ParseDuration(r["delay"].(string))
+	policy.MaxDelay, _ = time.ParseDuration(r["max_delay"].(string))
+	for _, code := range r["retryable_status_codes"].([]interface{}) {
+		policy.RetryableStatusCodes = append(policy.RetryableStatusCodes, code.(int))
+	}
+	return policy
+}
+
+// validateDuration checks the value is a Go duration string, e.g. 30s
+func validateDuration(v interface{}, k string) (ws []string, es []error) {
+	value := v.(string)
+	if _, err := time.ParseDuration(value); err != nil {
+		es = append(es, fmt.Errorf("%q is not a valid duration: %v", k, err))
+	}
+	return
 }
@@ -1,25 +1,44 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
 	"github.com/MayaraCloud/terraform-provider-anthos/hub"
 	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func resourceGkeConnectAgent() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceGkeConnectAgentCreate,
-		Read:   resourceGkeConnectAgentRead,
-		Update: resourceGkeConnectAgentUpdate,
-		Delete: resourceGkeConnectAgentDelete,
+		CreateContext: resourceGkeConnectAgentCreate,
+		ReadContext:   resourceGkeConnectAgentRead,
+		UpdateContext: resourceGkeConnectAgentUpdate,
+		DeleteContext: resourceGkeConnectAgentDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGkeConnectAgentImport,
+		},
+
+		// Installation and upgrades wait for the agent to be ready,
+		// uninstallation waits for the agent namespace to terminate
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"project": &schema.Schema{
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "GCP project id to which the hub registered cluster belongs",
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Defaults to the provider project. GCP project id to which the hub registered cluster belongs",
 			},
 			"cluster_name": &schema.Schema{
 				Type:        schema.TypeString,
@@ -27,6 +46,13 @@ func resourceGkeConnectAgent() *schema.Resource {
 				Description: "Kubernetes cluster name in the hub registry",
 				ForceNew:    true,
 			},
+			"location": &schema.Schema{
+				Type:        schema.TypeString,
+				Default:     hub.DefaultLocation,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Hub location of the membership the agent belongs to",
+			},
 			"description": &schema.Schema{
 				Type:        schema.TypeString,
 				Required:    false,
@@ -37,16 +63,16 @@ func resourceGkeConnectAgent() *schema.Resource {
 				Type:          schema.TypeString,
 				Required:      false,
 				Optional:      true,
-				Description:   "Kubernetes specific credentials file",
+				Description:   "Kubernetes specific credentials file, defaults to the provider one or ~/.kube/config",
 				ConflictsWith: []string{"k8s_context"},
 			},
 			"k8s_context": &schema.Schema{
 				Type:          schema.TypeString,
-				Default:       "current",
 				Required:      false,
 				Optional:      true,
-				Description:   "Use a context of the default credentials file",
+				Description:   "Use a context of the default credentials file, defaults to the provider one or the current context",
 				ConflictsWith: []string{"k8s_config_file"},
+				ForceNew:      true,
 			},
 			"namespace": &schema.Schema{
 				Type:        schema.TypeString,
@@ -54,6 +80,7 @@ func resourceGkeConnectAgent() *schema.Resource {
 				Required:    false,
 				Optional:    true,
 				Description: "Namespace to install connect agent to",
+				ForceNew:    true,
 			},
 			"proxy": &schema.Schema{
 				Type:        schema.TypeString,
@@ -97,39 +124,185 @@ func resourceGkeConnectAgent() *schema.Resource {
 				Sensitive:   true,
 				Description: "GCP Service Account content (as string) to be used as Connect-Agent K8s secret",
 			},
+			"deployed_version": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Version of the connect agent running in the cluster, from the deployment version label",
+			},
+			"ready": &schema.Schema{
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "True if all the connect agent replicas are ready",
+			},
 		},
 	}
 }
 
-func resourceGkeConnectAgentCreate(d *schema.ResourceData, m interface{}) error {
-	var k8sAuth k8s.Auth
-	k8sAuth.KubeConfigFile = d.Get("k8s_config_file").(string)
-	k8sAuth.KubeContext = d.Get("k8s_context").(string)
+func resourceGkeConnectAgentCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "project")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if diags := append(checkK8sAuth(d, k8sAuth), checkGCPSAKey(d)...); diags.HasError() {
+		return diags
+	}
 	ca := initConnectAgent(d, m)
-	err := ca.InstallOrUpdateConnectAgent(d.Get("project").(string), d.Get("cluster_name").(string), k8sAuth)
+	err = ca.InstallOrUpdateConnectAgent(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), k8sAuth)
 	if err != nil {
-		return fmt.Errorf("Installing or updating connect agent: %w", err)
+		return diag.Errorf("Installing or updating connect agent: %v", err)
 	}
-	d.SetId("test")
-	return resourceGkeConnectAgentRead(d, m)
+	d.Set("project", project)
+	d.SetId(connectAgentID(project, d.Get("location").(string), d.Get("cluster_name").(string), d.Get("namespace").(string)))
+	return resourceGkeConnectAgentRead(ctx, d, m)
 }
 
-func resourceGkeConnectAgentRead(d *schema.ResourceData, m interface{}) error {
+func resourceGkeConnectAgentRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	status, err := k8s.GetGKEConnectAgentStatus(ctx, k8sAuth, d.Get("namespace").(string))
+	if err != nil {
+		return diag.Errorf("Reading connect agent status: %v", err)
+	}
+	// The agent was removed out of band, let Terraform plan a new installation
+	if !status.Installed {
+		debug.GoLog("resourceGkeConnectAgentRead: connect agent not found in namespace " + d.Get("namespace").(string) + ", removing it from state")
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("deployed_version", status.Version)
+	d.Set("ready", status.Ready)
+	// We do not want the cluster secret in the state, we just flag
+	// the key as changed if the secret is gone or has other contents
+	if status.GCPSAKey != d.Get("gcp_sa_key").(string) {
+		d.Set("gcp_sa_key", "")
+	}
+
 	return nil
 }
 
-func resourceGkeConnectAgentUpdate(d *schema.ResourceData, m interface{}) error {
-	return resourceGkeConnectAgentRead(d, m)
-}
+func resourceGkeConnectAgentUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "project")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	diags := checkK8sAuth(d, k8sAuth)
+	if d.HasChange("gcp_sa_key") {
+		diags = append(diags, checkGCPSAKey(d)...)
+	}
+	if diags.HasError() {
+		return diags
+	}
+	ca := initConnectAgent(d, m)
+	// The agent is already there, so always go with upgrade semantics
+	ca.IsUpgrade = true
+	ca.UpdateGCPSAKey = d.HasChange("gcp_sa_key")
+	err = ca.InstallOrUpdateConnectAgent(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), k8sAuth)
+	if err != nil {
+		return diag.Errorf("Updating connect agent: %v", err)
+	}
 
-func resourceGkeConnectAgentDelete(d *schema.ResourceData, m interface{}) error {
-	var k8sAuth k8s.Auth
+	return resourceGkeConnectAgentRead(ctx, d, m)
+}
 
-	k8sAuth.KubeConfigFile = d.Get("k8s_config_file").(string)
-	k8sAuth.KubeContext = d.Get("k8s_context").(string)
+func resourceGkeConnectAgentDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "project")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	ca := initConnectAgent(d, m)
+	// Ask for the manifests of the version actually running so cluster scoped objects match
+	if ca.Version == "" {
+		ca.Version = d.Get("deployed_version").(string)
+	}
+	err = ca.UninstallConnectAgent(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), k8sAuth)
+	if err != nil {
+		return diag.Errorf("Uninstalling connect agent: %v", err)
+	}
 	return nil
 }
 
+// resourceGkeConnectAgentImport adopts a connect agent already installed in a cluster.
+// The ID must be projects/{project}/locations/{location}/memberships/{membership}/namespaces/{namespace}
+// or {project}/{membership}/{namespace} for agents of global memberships. Both can be
+// followed by /{k8s_context} to use a context other than the provider default one.
+// gcp_sa_key can not be read back and has to be supplied in the configuration
+func resourceGkeConnectAgentImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	project, location, membershipID, namespace, kubeContext, err := parseConnectAgentImportID(d.Id())
+	if err != nil {
+		return nil, fmt.Errorf("Importing connect agent: %w", err)
+	}
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	if kubeContext != "" {
+		k8sAuth.KubeContext = kubeContext
+		d.Set("k8s_context", kubeContext)
+	}
+	status, err := k8s.GetGKEConnectAgentStatus(ctx, k8sAuth, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("Importing connect agent: %w", err)
+	}
+	if !status.Installed {
+		return nil, fmt.Errorf("Importing connect agent: no connect agent found in namespace %v", namespace)
+	}
+
+	d.Set("project", project)
+	d.Set("location", location)
+	d.Set("cluster_name", membershipID)
+	d.Set("namespace", namespace)
+	d.Set("version", status.Version)
+	d.Set("proxy", status.Proxy)
+	// An empty registry means the default one
+	if status.Registry != k8s.ConnectAgentDefaultRegistry {
+		d.Set("registry", status.Registry)
+	}
+	// Import does not apply schema defaults, set them so the next plan is clean
+	d.Set("is_upgrade", false)
+	d.Set("image_pull_secret_content", "")
+	d.SetId(connectAgentID(project, location, membershipID, namespace))
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// parseConnectAgentImportID splits a connect agent import ID into its project, location,
+// membership ID, namespace and optional kube context. IDs not starting with projects/
+// are the former {project}/{membership}/{namespace}[/{k8s_context}] form, for global memberships
+func parseConnectAgentImportID(id string) (project string, location string, membershipID string, namespace string, kubeContext string, err error) {
+	// Kube contexts may contain slashes (e.g. EKS ARNs), keep them in the last part
+	parts := strings.SplitN(id, "/", 9)
+	switch {
+	case len(parts) >= 8 && parts[0] == "projects" && parts[2] == "locations" && parts[4] == "memberships" && parts[6] == "namespaces":
+		project, location, membershipID, namespace = parts[1], parts[3], parts[5], parts[7]
+		if len(parts) == 9 {
+			kubeContext = parts[8]
+		}
+	default:
+		parts = strings.SplitN(id, "/", 4)
+		if len(parts) < 3 {
+			return "", "", "", "", "", fmt.Errorf("unexpected ID %v, expected projects/{project}/locations/{location}/memberships/{membership}/namespaces/{namespace}[/{k8s_context}] or {project}/{membership}/{namespace}[/{k8s_context}]", id)
+		}
+		project, location, membershipID, namespace = parts[0], hub.DefaultLocation, parts[1], parts[2]
+		if len(parts) == 4 {
+			kubeContext = parts[3]
+		}
+	}
+	if project == "" || location == "" || membershipID == "" || namespace == "" {
+		return "", "", "", "", "", fmt.Errorf("project, location, membership and namespace can not be empty in ID %v", id)
+	}
+	return project, location, membershipID, namespace, kubeContext, nil
+}
+
+// connectAgentID builds the stable resource ID of a connect agent
+func connectAgentID(project string, location string, membershipID string, namespace string) string {
+	return fmt.Sprintf("projects/%v/locations/%v/memberships/%v/namespaces/%v", project, location, membershipID, namespace)
+}
+
 func initConnectAgent(d *schema.ResourceData, m interface{}) hub.ConnectAgent {
 
 	return hub.ConnectAgent{
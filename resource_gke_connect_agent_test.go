@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestParseConnectAgentImportID(t *testing.T) {
+	cases := []struct {
+		id           string
+		project      string
+		location     string
+		membershipID string
+		namespace    string
+		kubeContext  string
+		wantErr      bool
+	}{
+		{id: "my-project/my-cluster/gke-connect", project: "my-project", location: "global", membershipID: "my-cluster", namespace: "gke-connect"},
+		{id: "my-project/my-cluster/gke-connect/my-context", project: "my-project", location: "global", membershipID: "my-cluster", namespace: "gke-connect", kubeContext: "my-context"},
+		{id: "my-project/my-cluster/gke-connect/arn:aws:eks:eu-west-1:123456789012:cluster/my-cluster", project: "my-project", location: "global", membershipID: "my-cluster", namespace: "gke-connect", kubeContext: "arn:aws:eks:eu-west-1:123456789012:cluster/my-cluster"},
+		{id: "projects/my-project/locations/global/memberships/my-cluster/namespaces/gke-connect", project: "my-project", location: "global", membershipID: "my-cluster", namespace: "gke-connect"},
+		{id: "projects/my-project/locations/europe-west1/memberships/my-cluster/namespaces/gke-connect", project: "my-project", location: "europe-west1", membershipID: "my-cluster", namespace: "gke-connect"},
+		{id: "projects/my-project/locations/europe-west1/memberships/my-cluster/namespaces/gke-connect/my-context", project: "my-project", location: "europe-west1", membershipID: "my-cluster", namespace: "gke-connect", kubeContext: "my-context"},
+		{id: "projects/my-project/locations/global/memberships/my-cluster/namespaces/gke-connect/arn:aws:eks:eu-west-1:123456789012:cluster/my-cluster", project: "my-project", location: "global", membershipID: "my-cluster", namespace: "gke-connect", kubeContext: "arn:aws:eks:eu-west-1:123456789012:cluster/my-cluster"},
+		{id: "my-project/my-cluster", wantErr: true},
+		{id: "my-project//gke-connect", wantErr: true},
+		{id: "/my-cluster/gke-connect", wantErr: true},
+		{id: "my-project/my-cluster/", wantErr: true},
+		{id: "projects/my-project/locations//memberships/my-cluster/namespaces/gke-connect", wantErr: true},
+		{id: "projects/my-project/locations/global/memberships/my-cluster/namespaces/", wantErr: true},
+	}
+	for _, c := range cases {
+		project, location, membershipID, namespace, kubeContext, err := parseConnectAgentImportID(c.id)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseConnectAgentImportID(%q): expected an error, got %v, %v, %v, %v, %v", c.id, project, location, membershipID, namespace, kubeContext)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseConnectAgentImportID(%q): unexpected error: %v", c.id, err)
+			continue
+		}
+		if project != c.project || location != c.location || membershipID != c.membershipID || namespace != c.namespace || kubeContext != c.kubeContext {
+			t.Errorf("parseConnectAgentImportID(%q) = %v, %v, %v, %v, %v, expected %v, %v, %v, %v, %v", c.id, project, location, membershipID, namespace, kubeContext, c.project, c.location, c.membershipID, c.namespace, c.kubeContext)
+		}
+	}
+}
+
+func TestConnectAgentIDRoundTrip(t *testing.T) {
+	id := connectAgentID("my-project", "europe-west1", "my-cluster", "gke-connect")
+	project, location, membershipID, namespace, kubeContext, err := parseConnectAgentImportID(id)
+	if err != nil {
+		t.Fatalf("parseConnectAgentImportID(%q): unexpected error: %v", id, err)
+	}
+	if project != "my-project" || location != "europe-west1" || membershipID != "my-cluster" || namespace != "gke-connect" || kubeContext != "" {
+		t.Errorf("parseConnectAgentImportID(%q) = %v, %v, %v, %v, %v", id, project, location, membershipID, namespace, kubeContext)
+	}
+}
@@ -1,52 +1,106 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/MayaraCloud/terraform-provider-anthos/debug"
 	"github.com/MayaraCloud/terraform-provider-anthos/hub"
 	"github.com/MayaraCloud/terraform-provider-anthos/k8s"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func resourceMembership() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceMembershipCreate,
-		Read:   resourceMembershipRead,
-		Update: resourceMembershipUpdate,
-		Delete: resourceMembershipDelete,
+		CreateContext: resourceMembershipCreate,
+		ReadContext:   resourceMembershipRead,
+		UpdateContext: resourceMembershipUpdate,
+		DeleteContext: resourceMembershipDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceMembershipImport,
+		},
+		CustomizeDiff: resourceMembershipCustomizeDiff,
+
+		// Registration and deletion wait on hub operations and on the cluster
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"hub_project_id": &schema.Schema{
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "GCP project id in which the cluster will be registered",
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Defaults to the provider project. GCP project id in which the cluster will be registered",
 			},
 			"cluster_name": &schema.Schema{
 				Type:        schema.TypeString,
 				Required:    true,
 				Description: "Kubernetes cluster to register, this will be the cluster name in the hub",
 				ForceNew:    true,
+				ValidateFunc: validation.All(
+					validation.StringLenBetween(1, hub.MembershipFieldMaxLength),
+					validation.StringMatch(hub.MembershipIDRegexp, "must be a RFC 1123 DNS label"),
+				),
+			},
+			"location": &schema.Schema{
+				Type:        schema.TypeString,
+				Default:     hub.DefaultLocation,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Hub location of the membership, e.g. global or a region",
 			},
 			"description": &schema.Schema{
 				Type:        schema.TypeString,
 				Required:    false,
 				Optional:    true,
-				Description: "Description of the kubernetes cluster",
+				Computed:    true,
+				Description: "Description of the kubernetes cluster, defaults to cluster_name",
+				ValidateFunc: validation.All(
+					validation.StringLenBetween(1, hub.MembershipFieldMaxLength),
+					validation.StringMatch(hub.DescriptionRegexp, "must start with an alphanumeric character and contain only alphanumerics, spaces, '_', '-' and '.'"),
+				),
+			},
+			"labels": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "GCP labels for the membership",
+			},
+			"gke_cluster_resource_link": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				Description:      "Resource link of a GKE cluster, e.g. //container.googleapis.com/projects/my-project/locations/us-west1-a/clusters/my-cluster.\nIf set, the cluster is registered as a GKE membership without using a kubeconfig, and no connect agent is needed",
+				ConflictsWith:    []string{"k8s_config_file", "k8s_context"},
+				ValidateFunc:     validateGKEClusterResourceLink,
+				DiffSuppressFunc: suppressEquivalentGKEClusterResourceLink,
 			},
 			"k8s_config_file": &schema.Schema{
 				Type:          schema.TypeString,
 				Required:      false,
 				Optional:      true,
-				Description:   "Kubernetes specific credentials file",
-				ConflictsWith: []string{"k8s_context"},
+				Description:   "Kubernetes specific credentials file, defaults to the provider one or ~/.kube/config",
+				ConflictsWith: []string{"k8s_context", "gke_cluster_resource_link"},
 			},
 			"k8s_context": &schema.Schema{
 				Type:          schema.TypeString,
-				Default:       "current",
 				Required:      false,
 				Optional:      true,
-				Description:   "Use a context of the default credentials file",
-				ConflictsWith: []string{"k8s_config_file"},
+				Description:   "Use a context of the default credentials file, defaults to the provider one or the current context",
+				ConflictsWith: []string{"k8s_config_file", "gke_cluster_resource_link"},
 			},
 			"delete_artifacts_on_destroy": &schema.Schema{
 				Type:        schema.TypeBool,
@@ -55,39 +109,525 @@ func resourceMembership() *schema.Resource {
 				Optional:    true,
 				Description: "If true, when deleting the cluster from the Hub, delete also the artifacts installed in the Kubernetes cluster",
 			},
+			"external_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "External ID of the membership, defaults to the kube-system namespace UID of the cluster",
+				ValidateFunc: validation.All(
+					validation.StringLenBetween(1, hub.MembershipFieldMaxLength),
+					validation.StringMatch(hub.ExternalIDRegexp, "must start with an alphanumeric character and contain only alphanumerics, '_', '-' and '.'"),
+				),
+			},
+			"state": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Current state of the membership in the Hub",
+			},
+			"create_time": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Timestamp for when the membership was created",
+			},
+			"update_time": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Timestamp for when the membership was last updated",
+			},
+			"last_connection_time": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Timestamp of the most recent connection established with Google Cloud",
+			},
+			"authority": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Computed:    true,
+				MaxItems:    1,
+				Description: "How Google recognizes identities from this membership, used by fleet Workload Identity",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"issuer": &schema.Schema{
+							Type:        schema.TypeString,
+							Optional:    true,
+							Computed:    true,
+							Description: "JWT issuer URI, Google will attempt OIDC discovery on it",
+						},
+						"enable_workload_identity": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "If true and issuer is not set, the issuer is discovered from the cluster /.well-known/openid-configuration",
+						},
+						"upload_oidc_jwks": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "If true, the cluster /openid/v1/jwks is sent to the Hub, for issuers Google can not reach (e.g. private EKS or on-prem clusters).\nThe keys are uploaded again when the cluster rotates them",
+						},
+					},
+				},
+			},
+			"kubernetes_metadata": &schema.Schema{
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Kubernetes metadata of the cluster, as reported by the Hub. If the Hub has none, it is read from the cluster on creation and import",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"kubernetes_api_server_version": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Kubernetes API server version as reported by /version",
+						},
+						"node_provider_id": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Node provider as reported by the first node of the cluster",
+						},
+						"node_count": &schema.Schema{
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Number of nodes of the cluster",
+						},
+						"vcpu_count": &schema.Schema{
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "vCPU capacity of all the cluster nodes",
+						},
+						"memory_mb": &schema.Schema{
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Memory capacity of all the cluster nodes in MB",
+						},
+						"update_time": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Timestamp for when the Hub last updated the metadata, empty if read from the cluster",
+						},
+					},
+				},
+			},
+			"kubernetes_resource": &schema.Schema{
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Kubernetes resources the Hub expects in the cluster, as reported by the Hub",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"membership_resources": &schema.Schema{
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "Resources to apply to the cluster after the membership creation and after every update",
+							Elem:        resourceManifestSchema(),
+						},
+						"connect_resources": &schema.Schema{
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "Resources to install the GKE connect agent",
+							Elem:        resourceManifestSchema(),
+						},
+						"connect_version": &schema.Schema{
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Connect agent version used for connect_resources",
+						},
+						"v1beta1_crd": &schema.Schema{
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "True if the CustomResourceDefinition resources use apiextensions/v1beta1",
+						},
+					},
+				},
+			},
+			"oidc_jwks": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "OIDC JWKS known by the Hub for this membership, if uploaded",
+			},
+			"oidc_jwks_sha256": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "SHA-256 of the OIDC JWKS known by the Hub, used to upload the keys again when the cluster rotates them",
+			},
+			"identity_namespace": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Workload Identity namespace in which the membership issuer is recognized",
+			},
+			"identity_provider": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Identity provider that reflects the membership issuer in the identity namespace",
+			},
 		},
 	}
 }
 
-func resourceMembershipCreate(d *schema.ResourceData, m interface{}) error {
-	var k8sAuth k8s.Auth
+func resourceMembershipCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "hub_project_id")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	gkeClusterResourceLink := d.Get("gke_cluster_resource_link").(string)
+	if gkeClusterResourceLink == "" {
+		if diags := checkK8sAuth(d, k8sAuth); diags.HasError() {
+			return diags
+		}
+	}
+	clusterUUID, err := hub.CreateMembership(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), d.Get("description").(string), d.Get("external_id").(string), expandLabels(d), gkeClusterResourceLink, expandAuthority(d), k8sAuth)
+	// The membership exists in the Hub, keep it in the state even if the rest failed
+	if clusterUUID != "" {
+		d.Set("hub_project_id", project)
+		d.SetId(clusterUUID)
+	}
+	if err != nil {
+		if clusterUUID != "" {
+			return diag.Diagnostics{{
+				Severity: diag.Error,
+				Summary:  "Membership registered but not completed",
+				Detail:   fmt.Sprintf("The membership was created in the Hub, but the cluster setup failed: %v. It is marked as tainted and will be replaced on the next apply", err),
+			}}
+		}
+		if hub.IsAlreadyExists(err) {
+			return diag.Errorf("Creating Membership: %v. Import it into the state with the ID %v/memberships/%v", err, hub.GetParentRef(project, d.Get("location").(string)), d.Get("cluster_name").(string))
+		}
+		if hub.IsPermissionDenied(err) {
+			return diag.Errorf("Creating Membership: %v. The provider credentials need the GKE Hub Admin role in project %v", err, project)
+		}
+		return diag.Errorf("Creating Membership: %v", err)
+	}
+	return resourceMembershipRead(ctx, d, m)
+}
+
+func resourceMembershipRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "hub_project_id")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	resource, err := hub.GetMembership(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), k8sAuth)
+	if err != nil {
+		// The membership was deleted out of band, let Terraform plan a recreate
+		if hub.IsNotFound(err) {
+			debug.GoLog("resourceMembershipRead: membership " + d.Get("cluster_name").(string) + " not found, removing it from state")
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("Reading Membership: %v", err)
+	}
+
+	d.Set("description", resource.Description)
+	d.Set("external_id", resource.ExternalID)
+	// The link is only tracked when it was configured or imported, and the
+	// configured spelling is kept while it points to the same cluster
+	if link, ok := d.GetOk("gke_cluster_resource_link"); ok && !suppressEquivalentGKEClusterResourceLink("gke_cluster_resource_link", link.(string), resource.Endpoint.GKECluster.ResourceLink, d) {
+		d.Set("gke_cluster_resource_link", resource.Endpoint.GKECluster.ResourceLink)
+	}
+	if err := d.Set("labels", resource.Labels); err != nil {
+		return diag.Errorf("Setting labels: %v", err)
+	}
+	d.Set("state", string(resource.State.Code))
+	d.Set("create_time", formatTime(resource.CreateTime))
+	d.Set("update_time", formatTime(resource.UpdateTime))
+	d.Set("last_connection_time", resource.LastConnectionTime)
+	d.Set("identity_namespace", resource.Authority.IdentityNamespace)
+	d.Set("identity_provider", resource.Authority.IdentityProvider)
+	// The Hub only knows the metadata of clusters with a connect agent, the others
+	// are read from the cluster once, so refreshes do not depend on reaching it
+	metadata := resource.Endpoint.KubernetesMetadata
+	if metadata != nil && !metadata.UpdateTime.IsZero() {
+		if err := d.Set("kubernetes_metadata", flattenKubernetesMetadata(metadata)); err != nil {
+			return diag.Errorf("Setting kubernetes_metadata: %v", err)
+		}
+	} else if d.IsNewResource() {
+		if err := setClusterMetadata(ctx, d, config, project, resource.Endpoint.GKECluster.ResourceLink, k8sAuth); err != nil {
+			// The metadata is informative only, do not fail the creation because of it
+			diags = append(diags, warning("Could not read the cluster metadata", err.Error()))
+		}
+	}
+	if err := d.Set("kubernetes_resource", flattenKubernetesResource(resource.Endpoint.KubernetesResource)); err != nil {
+		return diag.Errorf("Setting kubernetes_resource: %v", err)
+	}
+
+	d.Set("oidc_jwks", string(resource.Authority.OIDCJWKS))
+	d.Set("oidc_jwks_sha256", jwksHash(resource.Authority.OIDCJWKS))
+	// The API does not know about these flags, keep the configured values
+	authority := []map[string]interface{}{}
+	if resource.Authority.Issuer != "" {
+		authority = append(authority, map[string]interface{}{
+			"issuer":                   resource.Authority.Issuer,
+			"enable_workload_identity": d.Get("authority.0.enable_workload_identity").(bool),
+			"upload_oidc_jwks":         d.Get("authority.0.upload_oidc_jwks").(bool),
+		})
+	}
+	if err := d.Set("authority", authority); err != nil {
+		return diag.Errorf("Setting authority: %v", err)
+	}
+
+	return diags
+}
+
+// validateGKEClusterResourceLink checks that a GKE cluster resource link can be parsed
+func validateGKEClusterResourceLink(v interface{}, k string) (ws []string, es []error) {
+	if _, err := hub.GKEClusterPath(v.(string)); err != nil {
+		es = append(es, fmt.Errorf("%v: %w", k, err))
+	}
+	return
+}
 
-	k8sAuth.KubeConfigFile = d.Get("k8s_config_file").(string)
-	k8sAuth.KubeContext = d.Get("k8s_context").(string)
-	clusterUUID, err := hub.CreateMembership(d.Get("hub_project_id").(string), d.Get("cluster_name").(string), "", d.Get("description").(string), "", k8sAuth)
+// suppressEquivalentGKEClusterResourceLink ignores differences between
+// resource links pointing to the same GKE cluster, e.g. zones vs locations
+func suppressEquivalentGKEClusterResourceLink(k, old, new string, d *schema.ResourceData) bool {
+	oldPath, err := hub.GKEClusterPath(old)
+	if err != nil {
+		return false
+	}
+	newPath, err := hub.GKEClusterPath(new)
 	if err != nil {
-		return fmt.Errorf("Creating Membership: %w", err)
+		return false
 	}
-	d.SetId(clusterUUID)
-	return resourceMembershipRead(d, m)
+	return oldPath == newPath
 }
 
-func resourceMembershipRead(d *schema.ResourceData, m interface{}) error {
-	return nil
+// resourceManifestSchema is the schema of the Kubernetes resources reported by the Hub
+func resourceManifestSchema() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"manifest": &schema.Schema{
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "YAML manifest of the resource",
+			},
+			"cluster_scoped": &schema.Schema{
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "True if the resource is cluster scoped",
+			},
+		},
+	}
+}
+
+// setClusterMetadata reads the Kubernetes metadata straight from the membership cluster
+func setClusterMetadata(ctx context.Context, d *schema.ResourceData, config *Config, project string, gkeClusterResourceLink string, k8sAuth k8s.Auth) error {
+	metadata, err := hub.GetClusterMetadata(ctx, config.HubService, project, d.Get("location").(string), gkeClusterResourceLink, k8sAuth)
+	if err != nil {
+		return err
+	}
+	return d.Set("kubernetes_metadata", flattenKubernetesMetadata(&metadata))
+}
+
+// flattenKubernetesMetadata converts the cluster metadata into the kubernetes_metadata attribute
+func flattenKubernetesMetadata(metadata *hub.KubernetesMetadata) []map[string]interface{} {
+	return []map[string]interface{}{{
+		"kubernetes_api_server_version": metadata.KubernetesAPIServerVersion,
+		"node_provider_id":              metadata.NodeProviderID,
+		"node_count":                    int(metadata.NodeCount),
+		"vcpu_count":                    int(metadata.VCPUCount),
+		"memory_mb":                     int(metadata.MemoryMB),
+		"update_time":                   formatTime(metadata.UpdateTime),
+	}}
+}
+
+// flattenKubernetesResource converts the resources reported by the Hub into the kubernetes_resource attribute
+func flattenKubernetesResource(resource *hub.KubernetesResource) []map[string]interface{} {
+	if resource == nil {
+		return []map[string]interface{}{}
+	}
+	flattenManifests := func(manifests []hub.ResourceManifest) []map[string]interface{} {
+		flattened := []map[string]interface{}{}
+		for _, manifest := range manifests {
+			flattened = append(flattened, map[string]interface{}{
+				"manifest":       manifest.Manifest,
+				"cluster_scoped": manifest.ClusterScoped,
+			})
+		}
+		return flattened
+	}
+	return []map[string]interface{}{{
+		"membership_resources": flattenManifests(resource.MembershipResources),
+		"connect_resources":    flattenManifests(resource.ConnectResources),
+		"connect_version":      resource.ResourceOptions.ConnectVersion,
+		"v1beta1_crd":          resource.ResourceOptions.V1Beta1CRD,
+	}}
+}
+
+// expandAuthority converts the authority block into the hub options
+func expandAuthority(d *schema.ResourceData) hub.AuthorityOptions {
+	return hub.AuthorityOptions{
+		IssuerURL:              d.Get("authority.0.issuer").(string),
+		EnableWorkloadIdentity: d.Get("authority.0.enable_workload_identity").(bool),
+		UploadOIDCJWKS:         d.Get("authority.0.upload_oidc_jwks").(bool),
+	}
+}
+
+// jwksHash returns the hex SHA-256 of a JSON document ignoring insignificant
+// whitespace, or an empty string if there is no document
+func jwksHash(jwks []byte) string {
+	if len(jwks) == 0 {
+		return ""
+	}
+	var compact bytes.Buffer
+	if json.Compact(&compact, jwks) != nil {
+		compact.Reset()
+		compact.Write(jwks)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(compact.Bytes()))
+}
+
+// resourceMembershipCustomizeDiff plans an authority update when the cluster rotated the
+// OIDC signing keys of a membership uploading them. The cluster being unreachable
+// does not block the plan, the keys are compared again on the next one
+func resourceMembershipCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	// New memberships and authority changes upload the current keys anyway
+	if d.Id() == "" || d.HasChange("authority") || !d.Get("authority.0.upload_oidc_jwks").(bool) {
+		return nil
+	}
+	config := m.(*Config)
+	project, err := config.getProject(d, "hub_project_id")
+	if err != nil {
+		return nil
+	}
+	clusterJWKS, err := hub.GetClusterOIDCJWKS(ctx, config.HubService, project, d.Get("location").(string), d.Get("gke_cluster_resource_link").(string), config.getK8sAuth(d))
+	if err != nil {
+		debug.GoLog("resourceMembershipCustomizeDiff: can not read the cluster OIDC JWKS: " + err.Error())
+		return nil
+	}
+	hash := jwksHash(clusterJWKS)
+	if hash == d.Get("oidc_jwks_sha256").(string) {
+		return nil
+	}
+	debug.GoLog("resourceMembershipCustomizeDiff: the cluster OIDC JWKS changed, it will be uploaded again")
+	if err := d.SetNew("oidc_jwks_sha256", hash); err != nil {
+		return err
+	}
+	return d.SetNewComputed("oidc_jwks")
+}
+
+// expandLabels converts the labels attribute into the map the hub package expects
+func expandLabels(d *schema.ResourceData) map[string]string {
+	labels := make(map[string]string)
+	for key, value := range d.Get("labels").(map[string]interface{}) {
+		labels[key] = value.(string)
+	}
+	return labels
 }
 
-func resourceMembershipUpdate(d *schema.ResourceData, m interface{}) error {
-	return resourceMembershipRead(d, m)
+// formatTime returns a RFC3339 representation of t, or an empty string if t is not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
 }
 
-func resourceMembershipDelete(d *schema.ResourceData, m interface{}) error {
-	var k8sAuth k8s.Auth
+func resourceMembershipUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "hub_project_id")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	// Map the changed attributes to the API field names
+	var updateMask []string
+	if d.HasChange("description") {
+		updateMask = append(updateMask, "description")
+	}
+	if d.HasChange("external_id") {
+		updateMask = append(updateMask, "externalId")
+	}
+	if d.HasChange("labels") {
+		updateMask = append(updateMask, "labels")
+	}
+	// A new JWKS hash means the cluster rotated its keys, upload them again
+	if d.HasChange("authority") || d.HasChange("oidc_jwks_sha256") {
+		updateMask = append(updateMask, "authority")
+	}
+	if len(updateMask) > 0 {
+		err = hub.UpdateMembership(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), d.Get("description").(string), d.Get("external_id").(string), expandLabels(d), expandAuthority(d), updateMask, k8sAuth)
+		if err != nil {
+			return diag.Errorf("Updating Membership: %v", err)
+		}
+	}
+	return resourceMembershipRead(ctx, d, m)
+}
 
-	k8sAuth.KubeConfigFile = d.Get("k8s_config_file").(string)
-	k8sAuth.KubeContext = d.Get("k8s_context").(string)
-	err := hub.DeleteMembership(d.Get("hub_project_id").(string), d.Get("cluster_name").(string), "", d.Get("description").(string), "", k8sAuth, d.Get("delete_artifacts_on_destroy").(bool))
+func resourceMembershipDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+	k8sAuth := config.getK8sAuth(d)
+	project, err := config.getProject(d, "hub_project_id")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	deleteArtifacts := d.Get("delete_artifacts_on_destroy").(bool)
+	err = hub.DeleteMembership(ctx, config.HubService, project, d.Get("location").(string), d.Get("cluster_name").(string), k8sAuth, deleteArtifacts)
 	if err != nil {
-		return fmt.Errorf("Deleting Membership: %w", err)
+		// Nothing left to delete in the Hub. Other missing resources,
+		// e.g. the GKE cluster, must not leave the membership behind
+		if errors.Is(err, hub.ErrMembershipNotFound) {
+			return diag.Diagnostics{warning("Membership already deleted", fmt.Sprintf("Membership %v was not found in the Hub, the cluster artifacts were not deleted", d.Get("cluster_name").(string)))}
+		}
+		return diag.Errorf("Deleting Membership: %v", err)
+	}
+	if !deleteArtifacts {
+		return diag.Diagnostics{warning("Membership artifacts left behind", "The membership CRD and CR are still in the cluster, they keep it bound to the Hub project. Set delete_artifacts_on_destroy to remove them")}
 	}
 	return nil
 }
+
+// resourceMembershipImport adopts an existing membership. Accepted IDs are the
+// full membership name, projects/{project}/locations/{location}/memberships/{membership},
+// or the short form {project}/{membership} for global memberships
+func resourceMembershipImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	project, location, membershipID, err := parseMembershipImportID(d.Id())
+	if err != nil {
+		return nil, fmt.Errorf("Importing Membership: %w", err)
+	}
+
+	config := m.(*Config)
+	resource, err := hub.GetMembership(ctx, config.HubService, project, location, membershipID, config.getK8sAuth(d))
+	if err != nil {
+		return nil, fmt.Errorf("Importing Membership: %w", err)
+	}
+
+	d.Set("hub_project_id", project)
+	d.Set("location", location)
+	d.Set("cluster_name", membershipID)
+	d.Set("gke_cluster_resource_link", resource.Endpoint.GKECluster.ResourceLink)
+	// Read only takes the cluster metadata from the Hub after import
+	metadata := resource.Endpoint.KubernetesMetadata
+	if metadata == nil || metadata.UpdateTime.IsZero() {
+		if err := setClusterMetadata(ctx, d, config, project, resource.Endpoint.GKECluster.ResourceLink, config.getK8sAuth(d)); err != nil {
+			debug.GoLog("resourceMembershipImport: can not read the cluster metadata: " + err.Error())
+		}
+	}
+	// Import does not apply schema defaults, set them so the next plan is clean
+	d.Set("delete_artifacts_on_destroy", true)
+	// Memberships created by this provider use the cluster UUID as ID
+	if resource.ExternalID != "" {
+		d.SetId(resource.ExternalID)
+	} else {
+		d.SetId(resource.Name)
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// parseMembershipImportID splits a membership import ID into its project, location and membership ID
+func parseMembershipImportID(id string) (project string, location string, membershipID string, err error) {
+	parts := strings.Split(id, "/")
+	switch {
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "locations" && parts[4] == "memberships":
+		project, location, membershipID = parts[1], parts[3], parts[5]
+	case len(parts) == 2:
+		project, location, membershipID = parts[0], hub.DefaultLocation, parts[1]
+	default:
+		return "", "", "", fmt.Errorf("unexpected ID %v, expected projects/{project}/locations/{location}/memberships/{membership} or {project}/{membership}", id)
+	}
+	if project == "" || location == "" || membershipID == "" {
+		return "", "", "", fmt.Errorf("project, location and membership can not be empty in ID %v", id)
+	}
+	return project, location, membershipID, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseMembershipImportID(t *testing.T) {
+	cases := []struct {
+		id           string
+		project      string
+		location     string
+		membershipID string
+		wantErr      bool
+	}{
+		{id: "projects/my-project/locations/global/memberships/my-cluster", project: "my-project", location: "global", membershipID: "my-cluster"},
+		{id: "projects/my-project/locations/europe-west1/memberships/my-cluster", project: "my-project", location: "europe-west1", membershipID: "my-cluster"},
+		{id: "my-project/my-cluster", project: "my-project", location: "global", membershipID: "my-cluster"},
+		{id: "my-cluster", wantErr: true},
+		{id: "my-project/global/my-cluster", wantErr: true},
+		{id: "projects/my-project/zones/global/memberships/my-cluster", wantErr: true},
+		{id: "projects/my-project/locations//memberships/my-cluster", wantErr: true},
+		{id: "my-project/", wantErr: true},
+		{id: "/my-cluster", wantErr: true},
+	}
+	for _, c := range cases {
+		project, location, membershipID, err := parseMembershipImportID(c.id)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseMembershipImportID(%q): expected an error, got %v, %v, %v", c.id, project, location, membershipID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMembershipImportID(%q): unexpected error: %v", c.id, err)
+			continue
+		}
+		if project != c.project || location != c.location || membershipID != c.membershipID {
+			t.Errorf("parseMembershipImportID(%q) = %v, %v, %v, expected %v, %v, %v", c.id, project, location, membershipID, c.project, c.location, c.membershipID)
+		}
+	}
+}
+
+func TestJWKSHash(t *testing.T) {
+	compact := jwksHash([]byte(`{"keys":[{"kid":"a","kty":"RSA"}]}`))
+	indented := jwksHash([]byte("{\n  \"keys\": [\n    {\"kid\": \"a\", \"kty\": \"RSA\"}\n  ]\n}\n"))
+	if compact == "" || compact != indented {
+		t.Errorf("jwksHash should ignore insignificant whitespace, got %q and %q", compact, indented)
+	}
+	if rotated := jwksHash([]byte(`{"keys":[{"kid":"b","kty":"RSA"}]}`)); rotated == compact {
+		t.Errorf("jwksHash should change when the keys change, got %q for both", rotated)
+	}
+	if empty := jwksHash(nil); empty != "" {
+		t.Errorf("jwksHash(nil) = %q, expected an empty string", empty)
+	}
+}